@@ -0,0 +1,327 @@
+package x264
+
+import (
+	"context"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy is what AsyncEncoder does with a new frame when the input queue is full.
+type DropPolicy int
+
+// Drop policies.
+const (
+	// Wait until there is room in the queue.
+	DropNone DropPolicy = iota
+	// Drop the new frame.
+	DropNewest
+	// Drop the oldest queued frame to make room for the new one.
+	DropOldest
+)
+
+// AsyncOptions represent asynchronous encoding options.
+type AsyncOptions struct {
+	// Encoding options.
+	Options
+	// Capacity of the input queue and the packet channel, zero means 1.
+	QueueSize int
+	// Policy for frames that don't fit into the queue, DropNone by default.
+	// With dropped frames the output timing is correct only for frames encoded with EncodeAt and Options.VFRInput.
+	Drop DropPolicy
+}
+
+// AsyncEncoder encodes frames on its own goroutine, which makes all x264 calls.
+// Its methods are safe for concurrent use. Encoded packets are received from Packets,
+// which must be read until it is closed, otherwise encoding stops when the channel is full.
+type AsyncEncoder struct {
+	// frames dropped under backpressure, accessed atomically
+	dropped int64
+
+	in     chan asyncFrame
+	out    chan *Packet
+	policy DropPolicy
+
+	// read-locked while sending frames, so the input is closed only when nobody sends
+	mu sync.RWMutex
+	// closed by Close, unblocks senders
+	closing   chan struct{}
+	closeOnce sync.Once
+	// closed when Close gives up, the remaining frames are abandoned
+	stop     chan struct{}
+	stopOnce sync.Once
+	// closed when the encoding goroutine exits
+	done chan struct{}
+	// frames lost when encoding was stopped, set before done is closed
+	abandoned int
+
+	headers []Nal
+
+	errMu sync.Mutex
+	err   error
+}
+
+// asyncFrame is a queued frame.
+type asyncFrame struct {
+	im    image.Image
+	opts  *EncodeOptions
+	timed bool
+}
+
+// NewAsyncEncoder returns new asynchronous encoder.
+func NewAsyncEncoder(opts *AsyncOptions) (*AsyncEncoder, error) {
+	size := opts.QueueSize
+	if size <= 0 {
+		size = 1
+	}
+
+	a := &AsyncEncoder{
+		in:      make(chan asyncFrame, size),
+		out:     make(chan *Packet, size),
+		policy:  opts.Drop,
+		closing: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	encOpts := opts.Options
+	ready := make(chan error)
+
+	go func() {
+		defer close(a.done)
+		defer close(a.out)
+
+		enc, err := NewEncoder(nil, &encOpts)
+		if err == nil {
+			a.headers = enc.Headers()
+		}
+		ready <- err
+		if err != nil {
+			return
+		}
+		// run flushes the encoder itself, frames abandoned on cancel must not be flushed by FlushOnClose
+		defer enc.close()
+
+		a.run(enc)
+	}()
+
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// run encodes queued frames until the input is closed and flushes the encoder.
+func (a *AsyncEncoder) run(enc *Encoder) {
+	for f := range a.in {
+		if a.stopped() {
+			a.abandon(enc, 1)
+			return
+		}
+		if a.error() != nil {
+			// the frames after an error are discarded
+			continue
+		}
+
+		p, err := enc.encodeFrame(f.im, f.opts, f.timed)
+		if err != nil {
+			a.setError(err)
+			continue
+		}
+
+		if !a.send(p) {
+			a.abandon(enc, 1)
+			return
+		}
+	}
+
+	for a.error() == nil {
+		if a.stopped() {
+			a.abandon(enc, 0)
+			return
+		}
+
+		p, err := enc.FlushFrame()
+		if err != nil {
+			a.setError(err)
+			return
+		}
+		if p == nil {
+			return
+		}
+		if !a.send(p) {
+			a.abandon(enc, 1)
+			return
+		}
+	}
+}
+
+// abandon records the number of frames lost when encoding is stopped,
+// n frames that were not sent, the queued ones and the delayed ones.
+func (a *AsyncEncoder) abandon(enc *Encoder, n int) {
+	for range a.in {
+		n++
+	}
+	a.abandoned = n + int(encoderDelayedFrames(enc.e))
+}
+
+// send sends the packet to the output, it returns false when encoding is stopped.
+func (a *AsyncEncoder) send(p *Packet) bool {
+	if p == nil {
+		return true
+	}
+
+	select {
+	case a.out <- p:
+		return true
+	case <-a.stop:
+		return false
+	}
+}
+
+// Encode queues image for encoding.
+// The image must not be modified until its packet is received or it is dropped.
+func (a *AsyncEncoder) Encode(im image.Image) error {
+	return a.queue(asyncFrame{im: im})
+}
+
+// EncodeAt queues image presented at pts for encoding, see Encoder.EncodeFrameAt.
+func (a *AsyncEncoder) EncodeAt(im image.Image, pts time.Duration) error {
+	return a.queue(asyncFrame{im: im, opts: &EncodeOptions{Pts: pts}, timed: true})
+}
+
+// EncodeWith queues image with per-frame options for encoding.
+// The options and the SEI payloads are copied, so they can be reused right away.
+func (a *AsyncEncoder) EncodeWith(im image.Image, opts *EncodeOptions) error {
+	return a.queue(asyncFrame{im: im, opts: copyOptions(opts), timed: opts != nil && opts.Pts > 0})
+}
+
+// copyOptions returns a deep copy of the frame options.
+func copyOptions(opts *EncodeOptions) *EncodeOptions {
+	if opts == nil {
+		return nil
+	}
+
+	c := *opts
+	if opts.SEI != nil {
+		c.SEI = make([]SEIMessage, len(opts.SEI))
+		for i, m := range opts.SEI {
+			c.SEI[i] = SEIMessage{Type: m.Type, Payload: append([]byte(nil), m.Payload...)}
+		}
+	}
+
+	return &c
+}
+
+// queue adds the frame to the input queue according to the drop policy.
+// It returns the first encoding error, if any, and ErrClosed after Close.
+func (a *AsyncEncoder) queue(f asyncFrame) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	select {
+	case <-a.closing:
+		return ErrClosed
+	default:
+	}
+
+	if err := a.error(); err != nil {
+		return err
+	}
+
+	switch a.policy {
+	case DropNewest:
+		select {
+		case a.in <- f:
+		default:
+			atomic.AddInt64(&a.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case a.in <- f:
+				return nil
+			default:
+			}
+
+			select {
+			case <-a.in:
+				atomic.AddInt64(&a.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case a.in <- f:
+		case <-a.closing:
+			return ErrClosed
+		}
+	}
+
+	return nil
+}
+
+// Packets returns the channel of encoded packets, it is closed when encoding ends.
+func (a *AsyncEncoder) Packets() <-chan *Packet {
+	return a.out
+}
+
+// Headers returns the SPS and PPS NAL units of the stream.
+func (a *AsyncEncoder) Headers() []Nal {
+	return a.headers
+}
+
+// Dropped returns the number of frames dropped because the input queue was full.
+func (a *AsyncEncoder) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close stops accepting frames, encodes the queued ones, flushes delayed frames and closes the encoder.
+// When ctx is done first, the remaining frames are abandoned after the frame being encoded
+// and a *CancelError with their number is returned. Otherwise Close returns the first encoding error.
+func (a *AsyncEncoder) Close(ctx context.Context) error {
+	a.closeOnce.Do(func() {
+		close(a.closing)
+
+		a.mu.Lock()
+		close(a.in)
+		a.mu.Unlock()
+	})
+
+	select {
+	case <-a.done:
+		return a.error()
+	case <-ctx.Done():
+		a.stopOnce.Do(func() { close(a.stop) })
+		<-a.done
+		if a.abandoned == 0 {
+			return a.error()
+		}
+		return &CancelError{Abandoned: a.abandoned, Err: ctx.Err()}
+	}
+}
+
+func (a *AsyncEncoder) stopped() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *AsyncEncoder) error() error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	return a.err
+}
+
+func (a *AsyncEncoder) setError(err error) {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
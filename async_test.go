@@ -0,0 +1,260 @@
+package x264
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	col "github.com/sergystepanov/x264-go/v2/x264c/color"
+)
+
+// collectPackets reads packets until the channel is closed, wait returns them.
+func collectPackets(a *AsyncEncoder) (wait func() []*Packet) {
+	var packets []*Packet
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for p := range a.Packets() {
+			packets = append(packets, p)
+		}
+	}()
+
+	return func() []*Packet {
+		wg.Wait()
+		return packets
+	}
+}
+
+func TestAsyncEncoder(t *testing.T) {
+	opts := &AsyncOptions{Options: *testOptions(), QueueSize: 4}
+	opts.Tune = ""
+
+	a, err := NewAsyncEncoder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Headers()) == 0 {
+		t.Error("no headers")
+	}
+
+	wait := collectPackets(a)
+
+	// concurrent producers
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := newTestSource(opts.Width, opts.Height, 25)
+			for i := 0; i < 25; i++ {
+				// every frame gets its own image, queued images must not be modified
+				img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+				im, _ := src.Next()
+				copy(img.Y, im.(*col.YCbCr).Y)
+
+				if err := a.Encode(img); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = a.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := wait()
+	if len(packets) != 50 {
+		t.Errorf("got %d packets, want 50", len(packets))
+	}
+	for i := 1; i < len(packets); i++ {
+		if packets[i].Dts <= packets[i-1].Dts {
+			t.Errorf("packet %d: dts %d after %d", i, packets[i].Dts, packets[i-1].Dts)
+		}
+	}
+
+	if err = a.Encode(col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))); !errors.Is(err, ErrClosed) {
+		t.Errorf("encode after close: got %v, want %v", err, ErrClosed)
+	}
+	if err = a.Close(context.Background()); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestAsyncEncoderOptions(t *testing.T) {
+	a, err := NewAsyncEncoder(&AsyncOptions{Options: *testOptions(), QueueSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one options value and buffer reused for every frame
+	uuid := [16]byte{0x6e, 0x84, 0x6d, 0x0a, 0x1f, 0x2b, 0x4c, 0x9e, 0x8a, 0x2e, 0x55, 0x3a, 0x0f, 0x17, 0x99, 0x01}
+	sei := UserDataUnregistered(uuid, []byte{0})
+	opts := &EncodeOptions{SEI: []SEIMessage{sei}}
+
+	img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+	for i := 0; i < 8; i++ {
+		sei.Payload[16] = byte(i)
+		opts.ForceIDR = i == 3
+		if err = a.EncodeWith(img, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts.SEI = nil
+
+	wait := collectPackets(a)
+	if err = a.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	for i, p := range wait() {
+		if p.Type == FrameTypeIDR != (i == 0 || i == 3) {
+			t.Errorf("packet %d: got %v frame", i, p.Type)
+		}
+		for _, n := range splitNals(p.Bytes()) {
+			if n.typ != 6 {
+				continue
+			}
+			msgs, err := parseSei(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range msgs {
+				if m.typ == SEIUserDataUnregistered && bytes.HasPrefix(m.payload, uuid[:]) {
+					got = append(got, m.payload[16:]...)
+				}
+			}
+		}
+	}
+	if want := []byte{0, 1, 2, 3, 4, 5, 6, 7}; !bytes.Equal(got, want) {
+		t.Errorf("got SEI payloads %v, want %v", got, want)
+	}
+}
+
+func TestAsyncEncoderDrop(t *testing.T) {
+	for _, policy := range []DropPolicy{DropNewest, DropOldest} {
+		opts := &AsyncOptions{Options: *testOptions(), QueueSize: 2, Drop: policy}
+		opts.VFRInput, opts.TimebaseNum, opts.TimebaseDen = true, 1, 25
+
+		a, err := NewAsyncEncoder(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// packets are not read until all frames are queued, so the queues fill up
+		img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+		for i := 0; i < 20; i++ {
+			if err = a.EncodeAt(img, time.Duration(i)*40*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wait := collectPackets(a)
+		if err = a.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		packets := wait()
+		if a.Dropped() == 0 || int64(len(packets))+a.Dropped() != 20 {
+			t.Errorf("policy %d: got %d packets, %d dropped", policy, len(packets), a.Dropped())
+		}
+
+		if policy == DropOldest && len(packets) > 0 {
+			// the last frame is kept
+			if last := packets[len(packets)-1].Pts; last != 19 {
+				t.Errorf("policy %d: last pts %d, want 19", policy, last)
+			}
+		}
+	}
+}
+
+func TestAsyncEncoderCloseCancel(t *testing.T) {
+	opts := &AsyncOptions{Options: *testOptions(), QueueSize: 2}
+
+	a, err := NewAsyncEncoder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	for i := 0; i < 4; i++ {
+		if err = a.Encode(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nobody reads packets, so the encoder can't finish
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = a.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	n := 0
+	for range a.Packets() {
+		n++
+	}
+
+	var ce *CancelError
+	if !errors.As(err, &ce) || n+ce.Abandoned != 4 {
+		t.Errorf("got %d packets, error %v", n, err)
+	}
+}
+
+func TestAsyncEncoderCloseCancelFlushOnClose(t *testing.T) {
+	var encoded int64
+	opts := &AsyncOptions{Options: *testOptions(), QueueSize: 2}
+	opts.Tune = ""
+	opts.FlushOnClose = true
+	opts.OnFrameStats = func(FrameStats) { atomic.AddInt64(&encoded, 1) }
+
+	a, err := NewAsyncEncoder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	for i := 0; i < 10; i++ {
+		if err = a.Encode(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = a.Close(ctx)
+	n := 0
+	for range a.Packets() {
+		n++
+	}
+	<-a.done
+
+	// the abandoned frames are not encoded when the encoder is closed, only a packet that wasn't sent
+	var ce *CancelError
+	if !errors.As(err, &ce) || n+ce.Abandoned != 10 {
+		t.Fatalf("got %d packets, error %v", n, err)
+	}
+	if got := atomic.LoadInt64(&encoded); got > int64(n)+1 {
+		t.Errorf("got %d encoded frames after %d packets, %d abandoned", got, n, ce.Abandoned)
+	}
+}
+
+func TestAsyncEncoderError(t *testing.T) {
+	opts := &AsyncOptions{Options: *testOptions()}
+	opts.Preset = "bogus"
+
+	if _, err := NewAsyncEncoder(opts); !errors.Is(err, ErrInvalidPreset) {
+		t.Errorf("got %v, want %v", err, ErrInvalidPreset)
+	}
+}
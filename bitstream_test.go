@@ -0,0 +1,359 @@
+package x264
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Minimal H.264 Annex B reader used to check encoder output.
+
+var errShortBitstream = errors.New("short bitstream")
+
+// annexbNal is a NAL unit of an Annex B stream without its start code.
+type annexbNal struct {
+	refIdc  int
+	typ     int
+	payload []byte
+}
+
+// splitNals splits an Annex B byte stream into NAL units.
+func splitNals(b []byte) (nals []annexbNal) {
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+
+		if start >= 0 {
+			nals = append(nals, newAnnexbNal(bytes.TrimRight(b[start:i], "\x00")))
+		}
+		i += 2
+		start = i + 1
+	}
+	if start >= 0 && start < len(b) {
+		nals = append(nals, newAnnexbNal(b[start:]))
+	}
+
+	return
+}
+
+func newAnnexbNal(b []byte) annexbNal {
+	return annexbNal{refIdc: int(b[0]>>5) & 3, typ: int(b[0] & 0x1f), payload: b}
+}
+
+// rbsp strips the NAL header and emulation prevention bytes.
+func (n annexbNal) rbsp() []byte {
+	out := make([]byte, 0, len(n.payload))
+	zeros := 0
+	for _, c := range n.payload[1:] {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// bitReader reads RBSP bits, the first error sticks.
+type bitReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) u(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.b)*8 {
+			r.err = errShortBitstream
+			return 0
+		}
+		bit := (r.b[r.pos/8] >> uint(7-r.pos%8)) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool { return r.u(1) == 1 }
+
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.u(1) == 0 && r.err == nil {
+		zeros++
+		if zeros > 31 {
+			r.err = errShortBitstream
+			return 0
+		}
+	}
+	return (1<<uint(zeros) - 1) + r.u(zeros)
+}
+
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+// sps holds the sequence parameter set fields checked by the tests.
+type sps struct {
+	profileIdc      uint32
+	chromaFormatIdc uint32
+	bitDepthLuma    uint32
+	bitDepthChroma  uint32
+
+	widthMbs       uint32
+	heightMapUnits uint32
+	frameMbsOnly   bool
+	mbAdaptive     bool
+
+	cropping                                 bool
+	cropLeft, cropRight, cropTop, cropBottom uint32
+
+	vui vui
+}
+
+// vui holds the SPS video usability information.
+type vui struct {
+	present bool
+
+	aspectRatioPresent bool
+	aspectRatioIdc     uint32
+	sarWidth           uint32
+	sarHeight          uint32
+
+	overscanInfoPresent bool
+	overscanAppropriate bool
+
+	videoSignalTypePresent bool
+	videoFormat            uint32
+	fullRange              bool
+	colourDescPresent      bool
+	colourPrimaries        uint32
+	transfer               uint32
+	matrix                 uint32
+
+	chromaLocPresent bool
+	chromaLocTop     uint32
+	chromaLocBottom  uint32
+
+	timingInfoPresent bool
+	numUnitsInTick    uint32
+	timeScale         uint32
+	fixedFrameRate    bool
+
+	nalHrdPresent    bool
+	vclHrdPresent    bool
+	picStructPresent bool
+}
+
+// sar returns the sample aspect ratio, aspect_ratio_idc resolved with H.264 Table E-1.
+func (v *vui) sar() (w, h uint32) {
+	if !v.aspectRatioPresent {
+		return 0, 0
+	}
+	if v.aspectRatioIdc == 255 {
+		return v.sarWidth, v.sarHeight
+	}
+
+	table := [...][2]uint32{{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+		{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1}}
+	if int(v.aspectRatioIdc) >= len(table) {
+		return 0, 0
+	}
+	return table[v.aspectRatioIdc][0], table[v.aspectRatioIdc][1]
+}
+
+// width returns the displayed frame width.
+func (s *sps) width() int {
+	w := int(s.widthMbs) * 16
+	cropUnit := 1
+	if s.chromaFormatIdc == 1 || s.chromaFormatIdc == 2 {
+		cropUnit = 2
+	}
+	return w - cropUnit*int(s.cropLeft+s.cropRight)
+}
+
+// height returns the displayed frame height.
+func (s *sps) height() int {
+	mult := 1
+	if !s.frameMbsOnly {
+		mult = 2
+	}
+	h := int(s.heightMapUnits) * 16 * mult
+	cropUnit := mult
+	if s.chromaFormatIdc == 1 {
+		cropUnit *= 2
+	}
+	return h - cropUnit*int(s.cropTop+s.cropBottom)
+}
+
+// parseSps parses a sequence parameter set NAL unit.
+func parseSps(n annexbNal) (s sps, err error) {
+	r := &bitReader{b: n.rbsp()}
+
+	s.profileIdc = r.u(8)
+	r.u(8) // constraint flags
+	r.u(8) // level_idc
+	r.ue() // seq_parameter_set_id
+
+	s.chromaFormatIdc = 1
+	switch s.profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.chromaFormatIdc = r.ue()
+		if s.chromaFormatIdc == 3 {
+			r.u(1) // separate_colour_plane_flag
+		}
+		s.bitDepthLuma = r.ue()
+		s.bitDepthChroma = r.ue()
+		r.u(1) // qpprime_y_zero_transform_bypass_flag
+		if r.flag() {
+			lists := 8
+			if s.chromaFormatIdc == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if !r.flag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size && next != 0; j++ {
+					next = (last + r.se() + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	s.bitDepthLuma += 8
+	s.bitDepthChroma += 8
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.u(1)
+		r.se()
+		r.se()
+		for i := r.ue(); i > 0 && r.err == nil; i-- {
+			r.se()
+		}
+	}
+	r.ue() // max_num_ref_frames
+	r.u(1) // gaps_in_frame_num_value_allowed_flag
+
+	s.widthMbs = r.ue() + 1
+	s.heightMapUnits = r.ue() + 1
+	s.frameMbsOnly = r.flag()
+	if !s.frameMbsOnly {
+		s.mbAdaptive = r.flag()
+	}
+	r.u(1) // direct_8x8_inference_flag
+
+	if s.cropping = r.flag(); s.cropping {
+		s.cropLeft, s.cropRight, s.cropTop, s.cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+
+	v := &s.vui
+	if v.present = r.flag(); v.present {
+		if v.aspectRatioPresent = r.flag(); v.aspectRatioPresent {
+			v.aspectRatioIdc = r.u(8)
+			if v.aspectRatioIdc == 255 {
+				v.sarWidth, v.sarHeight = r.u(16), r.u(16)
+			}
+		}
+		if v.overscanInfoPresent = r.flag(); v.overscanInfoPresent {
+			v.overscanAppropriate = r.flag()
+		}
+		if v.videoSignalTypePresent = r.flag(); v.videoSignalTypePresent {
+			v.videoFormat = r.u(3)
+			v.fullRange = r.flag()
+			if v.colourDescPresent = r.flag(); v.colourDescPresent {
+				v.colourPrimaries, v.transfer, v.matrix = r.u(8), r.u(8), r.u(8)
+			}
+		}
+		if v.chromaLocPresent = r.flag(); v.chromaLocPresent {
+			v.chromaLocTop, v.chromaLocBottom = r.ue(), r.ue()
+		}
+		if v.timingInfoPresent = r.flag(); v.timingInfoPresent {
+			v.numUnitsInTick = r.u(32)
+			v.timeScale = r.u(32)
+			v.fixedFrameRate = r.flag()
+		}
+		// the fields after HRD parameters are not parsed
+		v.nalHrdPresent = r.flag()
+		if !v.nalHrdPresent {
+			v.vclHrdPresent = r.flag()
+		}
+		if !v.nalHrdPresent && !v.vclHrdPresent {
+			v.picStructPresent = r.flag()
+		}
+	}
+
+	err = r.err
+	return
+}
+
+// seiMessage is a message of an SEI NAL unit.
+type seiMessage struct {
+	typ     int
+	payload []byte
+}
+
+// parseSei parses the messages of an SEI NAL unit.
+func parseSei(n annexbNal) (msgs []seiMessage, err error) {
+	b := n.rbsp()
+	// up to the rbsp trailing bits
+	for len(b) > 1 {
+		var typ, size int
+		for len(b) > 0 && b[0] == 0xff {
+			typ += 255
+			b = b[1:]
+		}
+		if len(b) < 2 {
+			return nil, errShortBitstream
+		}
+		typ += int(b[0])
+		b = b[1:]
+		for len(b) > 0 && b[0] == 0xff {
+			size += 255
+			b = b[1:]
+		}
+		if len(b) < 1 {
+			return nil, errShortBitstream
+		}
+		size += int(b[0])
+		b = b[1:]
+
+		if size > len(b) {
+			return nil, errShortBitstream
+		}
+		msgs = append(msgs, seiMessage{typ: typ, payload: b[:size]})
+		b = b[size:]
+	}
+	return
+}
+
+// findSps returns the first SPS of an Annex B stream.
+func findSps(b []byte) (sps, error) {
+	for _, n := range splitNals(b) {
+		if n.typ == 7 {
+			return parseSps(n)
+		}
+	}
+	return sps{}, errors.New("no SPS found")
+}
@@ -0,0 +1,145 @@
+package x264
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Colorspace is the pixel format of the encoder input.
+type Colorspace int
+
+// Input colorspaces.
+const (
+	// YCbCr 4:2:0 planar, the default.
+	ColorspaceI420 Colorspace = iota
+	// YCbCr 4:2:2 planar, encoded as 4:2:2 with the high422 profile.
+	ColorspaceI422
+	// YCbCr 4:4:4 planar, encoded as 4:4:4 with the high444 profile.
+	ColorspaceI444
+	// YCbCr 4:2:0 with a Y plane and an interleaved CbCr plane.
+	ColorspaceNV12
+	// YCbCr 4:2:0 with a Y plane and an interleaved CrCb plane.
+	ColorspaceNV21
+	// Packed 24-bit B, G, R, encoded as 4:4:4 RGB with the high444 profile.
+	ColorspaceBGR
+	// Packed 32-bit B, G, R, A with the alpha ignored, encoded as 4:4:4 RGB with the high444 profile.
+	ColorspaceBGRA
+	// Packed 24-bit R, G, B, encoded as 4:4:4 RGB with the high444 profile.
+	ColorspaceRGB
+)
+
+var colorspaceNames = [...]string{
+	ColorspaceI420: "I420",
+	ColorspaceI422: "I422",
+	ColorspaceI444: "I444",
+	ColorspaceNV12: "NV12",
+	ColorspaceNV21: "NV21",
+	ColorspaceBGR:  "BGR",
+	ColorspaceBGRA: "BGRA",
+	ColorspaceRGB:  "RGB",
+}
+
+func (c Colorspace) String() string {
+	if c.valid() {
+		return colorspaceNames[c]
+	}
+	return fmt.Sprintf("Colorspace(%d)", int(c))
+}
+
+// valid reports whether c is a known colorspace.
+func (c Colorspace) valid() bool {
+	return c >= 0 && int(c) < len(colorspaceNames)
+}
+
+// csp returns the x264 colorspace constant.
+func (c Colorspace) csp() int32 {
+	return [...]int32{
+		ColorspaceI420: cspI420,
+		ColorspaceI422: cspI422,
+		ColorspaceI444: cspI444,
+		ColorspaceNV12: cspNv12,
+		ColorspaceNV21: cspNv21,
+		ColorspaceBGR:  cspBgr,
+		ColorspaceBGRA: cspBgra,
+		ColorspaceRGB:  cspRgb,
+	}[c]
+}
+
+// profiles are the x264 profile names from the least to the most capable.
+var profiles = []string{"baseline", "main", "high", "high10", "high422", "high444"}
+
+// minProfile returns the least capable profile that can encode the colorspace, empty if any can.
+func (c Colorspace) minProfile() string {
+	switch c {
+	case ColorspaceI422:
+		return "high422"
+	case ColorspaceI444, ColorspaceBGR, ColorspaceBGRA, ColorspaceRGB:
+		return "high444"
+	}
+	return ""
+}
+
+// profileRank returns the index of the profile name in profiles, -1 if it is unknown.
+func profileRank(name string) int {
+	for i, p := range profiles {
+		if strings.EqualFold(p, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// profile returns Options.Profile raised to the least profile that supports the input colorspace and bit depth.
+// Unknown profile names are returned as is, x264 rejects them.
+func (o *Options) profile() string {
+	min := o.Colorspace.minProfile()
+	if o.bitDepth() > 8 && profileRank(min) < profileRank("high10") {
+		min = "high10"
+	}
+	if o.Profile == "" || min == "" {
+		return o.Profile
+	}
+
+	if r := profileRank(o.Profile); r >= 0 && r < profileRank(min) {
+		return min
+	}
+	return o.Profile
+}
+
+// bitDepth returns the bits per sample of the encoded stream.
+func (o *Options) bitDepth() int {
+	if o.BitDepth == 0 {
+		return 8
+	}
+	return o.BitDepth
+}
+
+// validateColorspace checks the input colorspace and the bit depth, which the library must support.
+func (o *Options) validateColorspace() error {
+	if !o.Colorspace.valid() {
+		return fmt.Errorf("%w: unknown colorspace %v", ErrInvalidOptions, o.Colorspace)
+	}
+
+	depth := o.bitDepth()
+	if depth != 8 && depth != 10 {
+		return fmt.Errorf("%w: bit depth must be 8 or 10, got %d", ErrInvalidOptions, o.BitDepth)
+	}
+	if depth > 8 && !o.Colorspace.planar() {
+		return fmt.Errorf("%w: %d-bit input requires a planar colorspace, got %v", ErrInvalidOptions, depth, o.Colorspace)
+	}
+	if lib := int(bitDepth()); lib != 0 && lib != depth {
+		return fmt.Errorf("%w: %d-bit encoding requested, the library encodes %d-bit", ErrBitDepth, depth, lib)
+	}
+
+	return nil
+}
+
+// planar reports whether c is a planar YCbCr colorspace.
+func (c Colorspace) planar() bool {
+	return c == ColorspaceI420 || c == ColorspaceI422 || c == ColorspaceI444
+}
+
+// rgb reports whether c is a packed RGB colorspace.
+func (c Colorspace) rgb() bool {
+	return c == ColorspaceBGR || c == ColorspaceBGRA || c == ColorspaceRGB
+}
@@ -0,0 +1,128 @@
+package x264
+
+import (
+	"fmt"
+	"image"
+)
+
+// subsampling returns the chroma subsampling factors of the colorspace, frame sizes and crops are their multiples.
+func (c Colorspace) subsampling() (w, h int) {
+	switch c {
+	case ColorspaceI420, ColorspaceNV12, ColorspaceNV21:
+		return 2, 2
+	case ColorspaceI422:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+// alignment returns the multiples of frame sizes and crops, the chroma subsampling in the height of a field
+// for interlaced streams.
+func (o *Options) alignment() (w, h int) {
+	w, h = o.Colorspace.subsampling()
+	if o.interlaced() {
+		h *= 2
+	}
+	return
+}
+
+// frameSize returns the encoded frame size, Width and Height padded to the alignment.
+func (o *Options) frameSize() image.Point {
+	w, h := o.alignment()
+	return image.Pt(alignUp(o.Width, w), alignUp(o.Height, h))
+}
+
+// display returns the displayed part of the encoded frame, Crop aligned outward to the alignment.
+// Without Crop it is the whole frame, a padded odd size cannot be cropped in 4:2:0 or 4:2:2.
+func (o *Options) display() image.Rectangle {
+	size := o.frameSize()
+	if o.Crop.Empty() {
+		return image.Rectangle{Max: size}
+	}
+
+	w, h := o.alignment()
+	r := image.Rect(alignDown(o.Crop.Min.X, w), alignDown(o.Crop.Min.Y, h), alignUp(o.Crop.Max.X, w), alignUp(o.Crop.Max.Y, h))
+	return r.Intersect(image.Rectangle{Max: size})
+}
+
+// validateCrop checks that the crop rectangle lies inside the frame.
+func (o *Options) validateCrop() error {
+	if o.Crop.Empty() {
+		if o.Crop != (image.Rectangle{}) {
+			return fmt.Errorf("%w: crop rectangle %v is empty", ErrInvalidOptions, o.Crop)
+		}
+		return nil
+	}
+
+	if !o.Crop.In(image.Rect(0, 0, o.Width, o.Height)) {
+		return fmt.Errorf("%w: crop rectangle %v is outside the %dx%d frame", ErrInvalidOptions, o.Crop, o.Width, o.Height)
+	}
+
+	return nil
+}
+
+// applyCrop sets the frame size and the SPS cropping of the displayed part.
+func applyCrop(param *x264Param, opts *Options) {
+	size := opts.frameSize()
+	param.IWidth = int32(size.X)
+	param.IHeight = int32(size.Y)
+
+	r := opts.display()
+	setCrop(param, r.Min.X, r.Min.Y, size.X-r.Max.X, size.Y-r.Max.Y)
+}
+
+// pad repeats the last column and row of the image into the padding of the input frame.
+func (e *Encoder) pad() {
+	e.in.pad(e.opts.Width, e.opts.Height)
+}
+
+// padBytes repeats the last column and row of a w x h plane of elements of size bytes up to pw x ph.
+func padBytes(p []byte, stride, size, w, h, pw, ph int) {
+	for y := 0; y < h && w < pw; y++ {
+		row := p[y*stride:]
+		last := row[(w-1)*size : w*size]
+		for x := w; x < pw; x++ {
+			copy(row[x*size:], last)
+		}
+	}
+	for y := h; y < ph; y++ {
+		copy(p[y*stride:y*stride+pw*size], p[(h-1)*stride:])
+	}
+}
+
+// padSamples repeats the last column and row of a w x h plane of 16-bit samples up to pw x ph.
+func padSamples(p []uint16, stride, w, h, pw, ph int) {
+	for y := 0; y < h && w < pw; y++ {
+		row := p[y*stride:]
+		for x := w; x < pw; x++ {
+			row[x] = row[w-1]
+		}
+	}
+	for y := h; y < ph; y++ {
+		copy(p[y*stride:y*stride+pw], p[(h-1)*stride:])
+	}
+}
+
+// subsampleFactors returns the chroma subsampling factors of the ratio.
+func subsampleFactors(r image.YCbCrSubsampleRatio) (w, h int) {
+	switch r {
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+// divUp returns v/n rounded up, the chroma samples of v luma samples.
+func divUp(v, n int) int {
+	return (v + n - 1) / n
+}
+
+func alignUp(v, n int) int {
+	return (v + n - 1) / n * n
+}
+
+func alignDown(v, n int) int {
+	return v / n * n
+}
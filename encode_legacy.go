@@ -1,224 +1,81 @@
 // +build legacy
 
-// Package x264 provides H.264/MPEG-4 AVC codec encoder based on [x264](https://www.videolan.org/developers/x264.html) library.
 package x264
 
-import "C"
+import x264c "github.com/sergystepanov/x264-go/v2/x264c/legacy"
 
-import (
-	"fmt"
-	"github.com/sergystepanov/x264-go/v2/x264c/color"
-	"image"
-	"io"
-
-	x264c "github.com/sergystepanov/x264-go/v2/x264c/legacy"
+// Bindings of the bundled x264 sources.
+type (
+	x264T       = x264c.T
+	x264Nal     = x264c.Nal
+	x264Param   = x264c.Param
+	x264Picture = x264c.Picture
+	x264Zone    = x264c.Zone
 )
 
-// Logging constants.
 const (
-	LogNone int32 = iota - 1
-	LogError
-	LogWarning
-	LogInfo
-	LogDebug
+	cspI420 = x264c.CspI420
+	cspI422 = x264c.CspI422
+	cspI444 = x264c.CspI444
+	cspNv12 = x264c.CspNv12
+	cspNv21 = x264c.CspNv21
+	cspBgr  = x264c.CspBgr
+	cspBgra = x264c.CspBgra
+	cspRgb  = x264c.CspRgb
+
+	cspHighDepth = x264c.CspHighDepth
+
+	rcCqp = x264c.RcCqp
+	rcCrf = x264c.RcCrf
+	rcAbr = x264c.RcAbr
+
+	keyintMinAuto = x264c.KeyintMinAuto
+
+	paramBadName = x264c.ParamBadName
+
+	typeAuto = x264c.TypeAuto
+	typeIdr  = x264c.TypeIdr
+
+	picStructAuto            = x264c.PicStructAuto
+	picStructProgressive     = x264c.PicStructProgressive
+	picStructTopBottom       = x264c.PicStructTopBottom
+	picStructBottomTop       = x264c.PicStructBottomTop
+	picStructTopBottomTop    = x264c.PicStructTopBottomTop
+	picStructBottomTopBottom = x264c.PicStructBottomTopBottom
+	picStructDouble          = x264c.PicStructDouble
+	picStructTriple          = x264c.PicStructTriple
 )
 
-// Options represent encoding options.
-type Options struct {
-	// Frame width.
-	Width int
-	// Frame height.
-	Height int
-	// Frame rate.
-	FrameRate int
-	// Tunings: film, animation, grain, stillimage, psnr, ssim, fastdecode, zerolatency.
-	Tune string
-	// Presets: ultrafast, superfast, veryfast, faster, fast, medium, slow, slower, veryslow, placebo.
-	Preset string
-	// Profiles: baseline, main, high, high10, high422, high444.
-	Profile string
-	// Log level.
-	LogLevel int32
-}
-
-// Encoder type.
-type Encoder struct {
-	e *x264c.T
-	w io.Writer
-
-	img  *color.YCbCr
-	opts *Options
-
-	csp int32
-	pts int64
-
-	nnals int32
-	nals  []*x264c.Nal
-
-	picIn x264c.Picture
-}
-
-// NewEncoder returns new x264 encoder.
-func NewEncoder(w io.Writer, opts *Options) (e *Encoder, err error) {
-	e = &Encoder{}
-
-	e.w = w
-	e.pts = 0
-	e.opts = opts
-
-	e.csp = x264c.CspI420
-
-	e.nals = make([]*x264c.Nal, 3)
-	e.img = color.NewYCbCr(image.Rect(0, 0, e.opts.Width, e.opts.Height))
-
-	param := x264c.Param{}
-
-	if e.opts.Preset != "" && e.opts.Profile != "" {
-		ret := x264c.ParamDefaultPreset(&param, e.opts.Preset, e.opts.Tune)
-		if ret < 0 {
-			err = fmt.Errorf("x264: invalid preset/tune name")
-			return
-		}
-	} else {
-		x264c.ParamDefault(&param)
-	}
-
-	param.IWidth = int32(e.opts.Width)
-	param.IHeight = int32(e.opts.Height)
-
-	param.ICsp = e.csp
-	param.BVfrInput = 0
-	param.BRepeatHeaders = 1
-	param.BAnnexb = 1
-
-	param.ILogLevel = e.opts.LogLevel
-
-	if e.opts.FrameRate > 0 {
-		param.IFpsNum = uint32(e.opts.FrameRate)
-		param.IFpsDen = 1
-
-		param.IKeyintMax = int32(e.opts.FrameRate)
-		param.BIntraRefresh = 1
-	}
-
-	if e.opts.Profile != "" {
-		ret := x264c.ParamApplyProfile(&param, e.opts.Profile)
-		if ret < 0 {
-			err = fmt.Errorf("x264: invalid profile name")
-			return
-		}
-	}
-
-	// Allocate on create instead while encoding
-	var picIn x264c.Picture
-	ret := x264c.PictureAlloc(&picIn, e.csp, int32(e.opts.Width), int32(e.opts.Height))
-	if ret < 0 {
-		err = fmt.Errorf("x264: cannot allocate picture")
-		return
-	}
-	e.picIn = picIn
-	defer func() {
-		// Cleanup if intialization fail
-		if err != nil {
-			x264c.PictureClean(&picIn)
-		}
-	}()
-
-	e.e = x264c.EncoderOpen(&param)
-	if e.e == nil {
-		err = fmt.Errorf("x264: cannot open the encoder")
-		return
-	}
-
-	ret = x264c.EncoderHeaders(e.e, e.nals, &e.nnals)
-	if ret < 0 {
-		err = fmt.Errorf("x264: cannot encode headers")
-		return
-	}
-
-	if ret > 0 {
-		b := C.GoBytes(e.nals[0].PPayload, C.int(ret))
-		n, er := e.w.Write(b)
-		if er != nil {
-			err = er
-			return
-		}
-
-		if int(ret) != n {
-			err = fmt.Errorf("x264: error writing headers, size=%d, n=%d", ret, n)
-		}
-	}
-
-	return
-}
-
-// Encode encodes image.
-func (e *Encoder) Encode(im image.Image) (err error) {
-	var picOut x264c.Picture
-
-	e.img.ToYCbCr(im)
-
-	picIn := e.picIn
-	e.img.CopyToCPointer(picIn.Img.Plane[0], picIn.Img.Plane[1], picIn.Img.Plane[2])
-	picIn.IPts = e.pts
-	e.pts++
-
-	ret := x264c.EncoderEncode(e.e, e.nals, &e.nnals, &picIn, &picOut)
-	if ret < 0 {
-		err = fmt.Errorf("x264: cannot encode picture")
-		return
-	}
-
-	if ret > 0 {
-		b := C.GoBytes(e.nals[0].PPayload, C.int(ret))
-
-		n, er := e.w.Write(b)
-		if er != nil {
-			err = er
-			return
-		}
-
-		if int(ret) != n {
-			err = fmt.Errorf("x264: error writing payload, size=%d, n=%d", ret, n)
-		}
-	}
-
-	return
-}
-
-// Flush flushes encoder.
-func (e *Encoder) Flush() (err error) {
-	var picOut x264c.Picture
-
-	for x264c.EncoderDelayedFrames(e.e) > 0 {
-		ret := x264c.EncoderEncode(e.e, e.nals, &e.nnals, nil, &picOut)
-		if ret < 0 {
-			err = fmt.Errorf("x264: cannot encode picture")
-			return
-		}
-
-		if ret > 0 {
-			b := C.GoBytes(e.nals[0].PPayload, C.int(ret))
-
-			n, er := e.w.Write(b)
-			if er != nil {
-				err = er
-				return
-			}
-
-			if int(ret) != n {
-				err = fmt.Errorf("x264: error writing payload, size=%d, n=%d", ret, n)
-			}
-		}
-	}
+var (
+	paramDefault            = x264c.ParamDefault
+	paramDefaultPreset      = x264c.ParamDefaultPreset
+	paramApplyProfile       = x264c.ParamApplyProfile
+	paramApplyFastfirstpass = x264c.ParamApplyFastfirstpass
+	paramParse              = x264c.ParamParse
+	pictureAlloc            = x264c.PictureAlloc
+	pictureClean            = x264c.PictureClean
+	bitDepth                = x264c.BitDepth
+	zonesAlloc              = x264c.ZonesAlloc
+	zonesFree               = x264c.ZonesFree
+	seiSet                  = x264c.SeiSet
+	logSet                  = x264c.LogSet
+	logFree                 = x264c.LogFree
+	encoderOpen             = x264c.EncoderOpen
+	encoderHeaders          = x264c.EncoderHeaders
+	encoderEncode           = x264c.EncoderEncode
+	encoderDelayedFrames    = x264c.EncoderDelayedFrames
+	encoderParameters       = x264c.EncoderParameters
+	encoderReconfig         = x264c.EncoderReconfig
+	encoderClose            = x264c.EncoderClose
+)
 
-	return
-}
+// setupParam sets build specific parameters.
+func setupParam(param *x264Param, opts *Options) {}
 
-// Close closes encoder.
-func (e *Encoder) Close() error {
-	picIn := e.picIn
-	x264c.PictureClean(&picIn)
-	x264c.EncoderClose(e.e)
-	return nil
+// setCrop sets the frame cropping rectangle of the SPS.
+func setCrop(param *x264Param, left, top, right, bottom int) {
+	param.CropRect.Left = uint32(left)
+	param.CropRect.Top = uint32(top)
+	param.CropRect.Right = uint32(right)
+	param.CropRect.Bottom = uint32(bottom)
 }
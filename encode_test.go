@@ -2,16 +2,26 @@ package x264
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	col "github.com/sergystepanov/x264-go/v2/x264c/color"
-	x264c "github.com/sergystepanov/x264-go/v2/x264c/external"
 )
 
 func TestEncode(t *testing.T) {
@@ -24,7 +34,7 @@ func TestEncode(t *testing.T) {
 		Tune:      "zerolatency",
 		Preset:    "veryfast",
 		Profile:   "high",
-		LogLevel:  x264c.LogDebug,
+		LogLevel:  LogDebug,
 	}
 
 	enc, err := NewEncoder(buf, opts)
@@ -70,7 +80,7 @@ func TestEncodeFlush(t *testing.T) {
 		Tune:      "film",
 		Preset:    "fast",
 		Profile:   "high",
-		LogLevel:  x264c.LogDebug,
+		LogLevel:  LogDebug,
 	}
 
 	enc, err := NewEncoder(buf, opts)
@@ -105,3 +115,2291 @@ func TestEncodeFlush(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestEncodeRateControl(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "crf", opts: Options{RateControl: RateControlCRF, CRF: 23}},
+		{name: "crf capped", opts: Options{RateControl: RateControlCRF, CRF: 23, VBVMaxBitrate: 500, VBVBufferSize: 1000}},
+		{name: "cqp", opts: Options{RateControl: RateControlCQP, QP: 30}},
+		{name: "abr", opts: Options{RateControl: RateControlABR, Bitrate: 500}},
+		{name: "cbr", opts: Options{RateControl: RateControlCBR, Bitrate: 500, VBVInit: 0.9}},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBuffer(make([]byte, 0))
+
+		opts := test.opts
+		opts.Width = 320
+		opts.Height = 240
+		opts.FrameRate = 25
+		opts.Preset = "veryfast"
+		opts.Profile = "high"
+
+		enc, err := NewEncoder(buf, &opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+		for i := 0; i < 25; i++ {
+			img.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+			if err = enc.Encode(img); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}
+
+		if err = enc.Flush(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if err = enc.Close(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if buf.Len() == 0 {
+			t.Errorf("%s: empty output", test.name)
+		}
+	}
+}
+
+func TestRateControlValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{name: "default", opts: Options{}, ok: true},
+		{name: "crf out of range", opts: Options{CRF: 52}},
+		{name: "crf with bitrate", opts: Options{CRF: 20, Bitrate: 1000}},
+		{name: "crf vbv without buffer", opts: Options{VBVMaxBitrate: 1000}},
+		{name: "cqp with vbv", opts: Options{RateControl: RateControlCQP, VBVMaxBitrate: 1000, VBVBufferSize: 1000}},
+		{name: "cqp lossless", opts: Options{RateControl: RateControlCQP}, ok: true},
+		{name: "abr without bitrate", opts: Options{RateControl: RateControlABR}},
+		{name: "abr with vbv", opts: Options{RateControl: RateControlABR, Bitrate: 1000, VBVMaxBitrate: 1500, VBVBufferSize: 2000}, ok: true},
+		{name: "cbr", opts: Options{RateControl: RateControlCBR, Bitrate: 1000}, ok: true},
+		{name: "cbr maxrate mismatch", opts: Options{RateControl: RateControlCBR, Bitrate: 1000, VBVMaxBitrate: 2000}},
+		{name: "negative bitrate", opts: Options{RateControl: RateControlABR, Bitrate: -1}},
+		{name: "unknown mode", opts: Options{RateControl: RateControl(42)}},
+	}
+
+	for _, test := range tests {
+		err := test.opts.validateRateControl()
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+// encodeFrames encodes n frames with a moving line and returns the stream.
+func encodeFrames(t *testing.T, opts *Options, n int) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+
+	enc, err := NewEncoder(buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	for i := 0; i < n; i++ {
+		img.Set(i%opts.Width, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+		if err = enc.Encode(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestEncodeTiming(t *testing.T) {
+	tests := []struct {
+		name           string
+		num, den       int
+		numUnitsInTick uint32
+		timeScale      uint32
+	}{
+		{name: "25", num: 25, numUnitsInTick: 1, timeScale: 50},
+		{name: "30", num: 30, den: 1, numUnitsInTick: 1, timeScale: 60},
+		{name: "29.97", num: 30000, den: 1001, numUnitsInTick: 1001, timeScale: 60000},
+		{name: "60", num: 60, numUnitsInTick: 1, timeScale: 120},
+	}
+
+	for _, test := range tests {
+		opts := &Options{
+			Width:        320,
+			Height:       240,
+			FrameRate:    test.num,
+			FrameRateDen: test.den,
+			TimebaseNum:  1,
+			TimebaseDen:  90000,
+			Preset:       "veryfast",
+			Profile:      "main",
+		}
+
+		s, err := findSps(encodeFrames(t, opts, 5))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		v := s.vui
+		if !v.timingInfoPresent {
+			t.Fatalf("%s: no timing info in SPS VUI", test.name)
+		}
+		if v.numUnitsInTick != test.numUnitsInTick || v.timeScale != test.timeScale || !v.fixedFrameRate {
+			t.Errorf("%s: got num_units_in_tick=%d time_scale=%d fixed=%v, want %d, %d, true",
+				test.name, v.numUnitsInTick, v.timeScale, v.fixedFrameRate, test.numUnitsInTick, test.timeScale)
+		}
+	}
+}
+
+func TestEncodePts(t *testing.T) {
+	opts := &Options{
+		Width:        320,
+		Height:       240,
+		FrameRate:    30000,
+		FrameRateDen: 1001,
+		TimebaseNum:  1,
+		TimebaseDen:  1000,
+		Preset:       "veryfast",
+		Profile:      "main",
+	}
+
+	enc, err := NewEncoder(ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// 33.366 ms per frame
+	want := []int64{0, 33, 67, 100, 133, 167, 200}
+	for i, pts := range want {
+		got, fields := enc.nextPts(PicStructAuto)
+		if got != pts {
+			t.Errorf("frame %d: got pts %d, want %d", i, got, pts)
+		}
+		enc.fields = fields
+	}
+}
+
+func TestTimingValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{name: "default", opts: Options{}, ok: true},
+		{name: "ntsc", opts: Options{FrameRate: 30000, FrameRateDen: 1001, TimebaseNum: 1, TimebaseDen: 90000}, ok: true},
+		{name: "negative rate", opts: Options{FrameRate: -1}},
+		{name: "keyint", opts: Options{KeyintMin: 25, KeyintMax: 250}, ok: true},
+		{name: "keyint min over max", opts: Options{KeyintMin: 30, KeyintMax: 25}},
+		{name: "timebase half set", opts: Options{TimebaseDen: 1000}},
+		{name: "coarse timebase", opts: Options{FrameRate: 60, TimebaseNum: 1, TimebaseDen: 30}},
+		{name: "timebase too big", opts: Options{TimebaseNum: 1, TimebaseDen: 1 << 31}},
+	}
+
+	for _, test := range tests {
+		err := test.opts.validateTiming()
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Preset:    "medium",
+		Profile:   "high",
+	}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	headers := enc.Headers()
+	if len(headers) < 2 || headers[0].Type != NalSps || headers[1].Type != NalPps {
+		t.Fatalf("expected SPS and PPS headers, got %v", headers)
+	}
+
+	var packets []*Packet
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	for i := 0; i < 30; i++ {
+		img.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+		p, err := enc.EncodeFrame(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			packets = append(packets, p)
+		}
+	}
+
+	for {
+		p, err := enc.FlushFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p == nil {
+			break
+		}
+		packets = append(packets, p)
+	}
+
+	if len(packets) != 30 {
+		t.Fatalf("got %d packets, want 30", len(packets))
+	}
+
+	first := packets[0]
+	if !first.Keyframe || first.Type != FrameTypeIDR || first.Pts != 0 {
+		t.Errorf("first packet: keyframe=%v type=%v pts=%d, want IDR keyframe at 0", first.Keyframe, first.Type, first.Pts)
+	}
+
+	pts := make(map[int64]bool)
+	dts := int64(math.MinInt64)
+	for i, p := range packets {
+		if p.Dts < dts {
+			t.Errorf("packet %d: dts %d goes back from %d", i, p.Dts, dts)
+		}
+		dts = p.Dts
+
+		if p.Dts > p.Pts {
+			t.Errorf("packet %d: dts %d after pts %d", i, p.Dts, p.Pts)
+		}
+		pts[p.Pts] = true
+
+		size := 0
+		for _, n := range p.Nals {
+			if !bytes.HasPrefix(n.Payload, []byte{0, 0, 1}) && !bytes.HasPrefix(n.Payload, []byte{0, 0, 0, 1}) {
+				t.Errorf("packet %d: NAL without start code", i)
+			}
+			size += len(n.Payload)
+		}
+		if size != len(p.Bytes()) {
+			t.Errorf("packet %d: NAL sizes %d don't add up to %d", i, size, len(p.Bytes()))
+		}
+
+		nals := splitNals(p.Bytes())
+		if len(nals) != len(p.Nals) {
+			t.Fatalf("packet %d: got %d NAL units in bytes, want %d", i, len(nals), len(p.Nals))
+		}
+		for j, n := range nals {
+			if NalType(n.typ) != p.Nals[j].Type || NalPriority(n.refIdc) != p.Nals[j].RefIdc {
+				t.Errorf("packet %d: NAL %d is %d/%d, want %d/%d", i, j, n.typ, n.refIdc, p.Nals[j].Type, p.Nals[j].RefIdc)
+			}
+		}
+	}
+
+	for i := int64(0); i < 30; i++ {
+		if !pts[i] {
+			t.Errorf("no packet with pts %d", i)
+		}
+	}
+}
+
+func TestEncodeAt(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		VFRInput:  true,
+		Tune:      "zerolatency",
+		Preset:    "veryfast",
+		Profile:   "high",
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+
+	enc, err := NewEncoder(buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+
+	// irregular capture times, the last frame has no timestamp and follows at 25 fps
+	times := []time.Duration{0, 40 * time.Millisecond, 95 * time.Millisecond, 101 * time.Millisecond, time.Second}
+	want := []int64{0, 3600, 8550, 9090, 90000, 93600}
+
+	var packets []*Packet
+	for i, ts := range times {
+		img.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+		p, err := enc.EncodeFrameAt(img, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			packets = append(packets, p)
+		}
+	}
+
+	p, err := enc.EncodeFrame(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != nil {
+		packets = append(packets, p)
+	}
+
+	for p, err = enc.FlushFrame(); p != nil; p, err = enc.FlushFrame() {
+		packets = append(packets, p)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(packets), len(want))
+	}
+	for i, p := range packets {
+		if p.Pts != want[i] {
+			t.Errorf("packet %d: got pts %d, want %d", i, p.Pts, want[i])
+		}
+		buf.Write(p.Bytes())
+	}
+
+	_, err = enc.EncodeFrameAt(img, time.Second)
+	var tsErr *TimestampError
+	if !errors.As(err, &tsErr) {
+		t.Fatalf("expected TimestampError, got %v", err)
+	}
+	if tsErr.Pts != 90000 || tsErr.Prev != 93600 {
+		t.Errorf("got %+v, want pts 90000 after 93600", tsErr)
+	}
+
+	s, err := findSps(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.vui; v.numUnitsInTick != 1 || v.timeScale != 180000 || v.fixedFrameRate {
+		t.Errorf("got num_units_in_tick=%d time_scale=%d fixed=%v, want 1, 180000, false",
+			v.numUnitsInTick, v.timeScale, v.fixedFrameRate)
+	}
+}
+
+func TestEncodeNewFrame(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Tune:      "zerolatency",
+		Preset:    "ultrafast",
+		Profile:   "baseline",
+	}
+
+	frame, err := NewFrame(opts.Width, opts.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frame.Free()
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+
+	var out [2]bytes.Buffer
+	for k, im := range []draw.Image{img, frame} {
+		enc, err := NewEncoder(&out[k], opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		draw.Draw(im, im.Bounds(), image.Black, image.ZP, draw.Src)
+		for i := 0; i < 10; i++ {
+			im.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(out[0].Bytes(), out[1].Bytes()) {
+		t.Error("frame and image outputs differ")
+	}
+}
+
+func benchmarkEncode(b *testing.B, im image.Image) {
+	opts := &Options{
+		Width:     640,
+		Height:    480,
+		FrameRate: 25,
+		Tune:      "zerolatency",
+		Preset:    "ultrafast",
+		Profile:   "baseline",
+	}
+
+	enc, err := NewEncoder(ioutil.Discard, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer enc.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err = enc.Encode(im); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeImage(b *testing.B) {
+	img := image.NewYCbCr(image.Rect(0, 0, 640, 480), image.YCbCrSubsampleRatio420)
+	benchmarkEncode(b, img)
+}
+
+func BenchmarkEncodeRGBA(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	benchmarkEncode(b, img)
+}
+
+func BenchmarkEncodeNewFrame(b *testing.B) {
+	frame, err := NewFrame(640, 480)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer frame.Free()
+
+	benchmarkEncode(b, frame)
+}
+
+func TestEncodeForceIDR(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		KeyintMax: 250,
+		Tune:      "zerolatency",
+		Preset:    "veryfast",
+		Profile:   "high",
+	}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+
+	idr := make(map[int64]bool)
+	check := func(p *Packet) {
+		if p == nil {
+			return
+		}
+
+		hasIdr := false
+		for _, n := range splitNals(p.Bytes()) {
+			if n.typ == int(NalSliceIdr) {
+				hasIdr = true
+			}
+		}
+		if hasIdr != (p.Type == FrameTypeIDR) {
+			t.Errorf("pts %d: IDR slices %v in a %v frame", p.Pts, hasIdr, p.Type)
+		}
+		if hasIdr {
+			idr[p.Pts] = true
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		img.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+
+		if i == 30 {
+			enc.ForceKeyframe()
+		}
+
+		p, err := enc.EncodeFrameWith(img, &EncodeOptions{ForceIDR: i == 12 || i == 13})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(p)
+	}
+	for p, err := enc.FlushFrame(); p != nil || err != nil; p, err = enc.FlushFrame() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(p)
+	}
+
+	want := map[int64]bool{0: true, 12: true, 13: true, 30: true}
+	if !reflect.DeepEqual(idr, want) {
+		t.Errorf("got IDR frames at %v, want %v", idr, want)
+	}
+}
+
+func TestEncodeReconfigure(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Tune:      "zerolatency",
+		Preset:    "veryfast",
+		Profile:   "high",
+		CRF:       18,
+	}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	if p := enc.Params(); p.RateControl != RateControlCRF || p.CRF != 18 {
+		t.Errorf("got params %+v", p)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	encode := func(n int) (size int) {
+		for i := 0; i < n; i++ {
+			for y := 0; y < opts.Height; y++ {
+				for x := 0; x < opts.Width; x++ {
+					img.Y[y*img.YStride+x] = uint8(x*x + y*7 + i*5)
+				}
+			}
+
+			p, err := enc.EncodeFrame(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				size += len(p.Bytes())
+			}
+		}
+		return
+	}
+
+	before := encode(10)
+
+	changed, err := enc.Reconfigure(func(p *Params) { p.CRF = 45 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"crf"}) {
+		t.Errorf("got changed %v", changed)
+	}
+
+	if after := encode(10); after >= before/2 {
+		t.Errorf("CRF 45 output %d bytes, CRF 18 %d bytes", after, before)
+	}
+
+	if changed, err = enc.Reconfigure(func(p *Params) {}); err != nil || changed != nil {
+		t.Errorf("no change: got %v, %v", changed, err)
+	}
+
+	for name, fn := range map[string]func(*Params){
+		"rate control": func(p *Params) { p.RateControl = RateControlABR },
+		"qp":           func(p *Params) { p.QP = 20 },
+		"crf range":    func(p *Params) { p.CRF = 60 },
+		"vbv":          func(p *Params) { p.VBVMaxBitrate, p.VBVBufferSize = 500, 500 },
+	} {
+		if _, err := enc.Reconfigure(fn); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err = enc.SetBitrate(500); err == nil {
+		t.Error("bitrate without VBV: expected an error")
+	}
+	if p := enc.Params(); p.CRF != 45 || p.VBVMaxBitrate != 0 {
+		t.Errorf("failed changes applied: %+v", p)
+	}
+}
+
+func TestEncodeReconfigureBitrate(t *testing.T) {
+	opts := &Options{
+		Width:       320,
+		Height:      240,
+		FrameRate:   25,
+		Preset:      "veryfast",
+		Profile:     "high",
+		RateControl: RateControlCBR,
+		Bitrate:     1000,
+	}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	changed, err := enc.Reconfigure(func(p *Params) { p.Bitrate = 500 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"bitrate", "vbv-maxrate"}) {
+		t.Errorf("got changed %v", changed)
+	}
+
+	if err = enc.SetCRF(20); err == nil {
+		t.Error("CRF with CBR: expected an error")
+	}
+	if _, err = enc.Reconfigure(func(p *Params) { p.VBVBufferSize = 0 }); err == nil {
+		t.Error("disabling VBV: expected an error")
+	}
+
+	want := Params{RateControl: RateControlCBR, Bitrate: 500, VBVMaxBitrate: 500, VBVBufferSize: 1000}
+	if p := enc.Params(); p.RateControl != want.RateControl || p.Bitrate != want.Bitrate ||
+		p.VBVMaxBitrate != want.VBVMaxBitrate || p.VBVBufferSize != want.VBVBufferSize {
+		t.Errorf("got params %+v, want %+v", p, want)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, opts.Width, opts.Height))
+	for i := 0; i < 10; i++ {
+		img.Set(i, opts.Height/2, color.RGBA{255, 0, 0, 255})
+		if _, err = enc.EncodeFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncodeParamsX264Params(t *testing.T) {
+	tests := []struct {
+		params string
+		want   Params
+	}{
+		{"bitrate=2000", Params{RateControl: RateControlABR, Bitrate: 2000}},
+		{"qp=20", Params{RateControl: RateControlCQP, QP: 20}},
+		{"bitrate=800:vbv-maxrate=800:vbv-bufsize=400",
+			Params{RateControl: RateControlCBR, Bitrate: 800, VBVMaxBitrate: 800, VBVBufferSize: 400}},
+		{"crf=21", Params{RateControl: RateControlCRF, CRF: 21}},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		opts.X264Params = test.params
+
+		enc, err := NewEncoder(nil, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.params, err)
+		}
+
+		p := enc.Params()
+		if test.want.RateControl != RateControlCRF {
+			p.CRF = 0
+		}
+		if p != test.want {
+			t.Errorf("%s: got params %+v, want %+v", test.params, p, test.want)
+		}
+
+		// changes are validated against the mode x264 uses
+		if err = enc.SetCRF(30); (err == nil) != (test.want.RateControl == RateControlCRF) {
+			t.Errorf("%s: SetCRF: got %v", test.params, err)
+		}
+		if err = enc.Resize(160, 120); err != nil {
+			t.Errorf("%s: resize: %v", test.params, err)
+		}
+		enc.Close()
+	}
+}
+
+// testSource is a replayable source of moving gradient frames.
+type testSource struct {
+	img    *col.YCbCr
+	frames int
+	i      int
+}
+
+func newTestSource(w, h, frames int) *testSource {
+	return &testSource{img: col.NewYCbCr(image.Rect(0, 0, w, h)), frames: frames}
+}
+
+func (s *testSource) Reset() error {
+	s.i = 0
+	return nil
+}
+
+func (s *testSource) Next() (image.Image, error) {
+	if s.i == s.frames {
+		return nil, io.EOF
+	}
+
+	b := s.img.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			s.img.Y[y*s.img.YStride+x] = uint8(x*x/7 + y*y/5 + s.i*3)
+		}
+	}
+	s.i++
+
+	return s.img, nil
+}
+
+func TestEncodeTwoPass(t *testing.T) {
+	dir, err := ioutil.TempDir("", "x264")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		opts  TwoPassOptions
+		bytes int64
+	}{
+		// 50 frames at 25 fps are 2 seconds
+		{name: "bitrate", opts: TwoPassOptions{TargetBitrate: 400}, bytes: 400 * 1000 / 8 * 2},
+		{name: "file size", opts: TwoPassOptions{FileSize: 60000}, bytes: 60000},
+		{name: "stats file", opts: TwoPassOptions{TargetBitrate: 300, StatsFile: filepath.Join(dir, "stats.log")}, bytes: 300 * 1000 / 8 * 2},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBuffer(make([]byte, 0))
+
+		opts := test.opts
+		opts.Width = 320
+		opts.Height = 240
+		opts.FrameRate = 25
+		opts.Preset = "veryfast"
+		opts.Profile = "high"
+
+		enc, err := NewTwoPassEncoder(buf, &opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if err = enc.Encode(newTestSource(opts.Width, opts.Height, 50)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if size := int64(buf.Len()); math.Abs(float64(size-test.bytes)) > float64(test.bytes)/5 {
+			t.Errorf("%s: got %d bytes, want about %d", test.name, size, test.bytes)
+		}
+
+		if opts.StatsFile != "" {
+			if _, err = os.Stat(opts.StatsFile); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}
+	}
+}
+
+func TestTwoPassValidation(t *testing.T) {
+	for name, opts := range map[string]TwoPassOptions{
+		"no target":    {},
+		"both":         {TargetBitrate: 100, FileSize: 1000},
+		"vfr":          {TargetBitrate: 100, Options: Options{VFRInput: true}},
+		"bad timing":   {TargetBitrate: 100, Options: Options{FrameRate: -1}},
+		"negative":     {TargetBitrate: -100},
+		"size too low": {FileSize: 1},
+		"rate control": {TargetBitrate: 100, Options: Options{RateControl: RateControlCBR}},
+		"crf":          {FileSize: 60000, Options: Options{CRF: 20}},
+		"qp":           {TargetBitrate: 100, Options: Options{QP: 10}},
+		"bitrate":      {TargetBitrate: 100, Options: Options{Bitrate: 200}},
+	} {
+		opts.Width, opts.Height = 320, 240
+
+		enc, err := NewTwoPassEncoder(nil, &opts)
+		if err == nil {
+			err = enc.Encode(newTestSource(opts.Width, opts.Height, 5))
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEncodeZones(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Tune:      "zerolatency",
+		Preset:    "veryfast",
+		Profile:   "high",
+		CRF:       20,
+		Zones: []Zone{
+			{Start: 10, End: 19, QP: 51},
+			{Start: 20, End: 29, BitrateFactor: 0.1},
+		},
+	}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	src := newTestSource(opts.Width, opts.Height, 40)
+	sizes := make([]int, 4)
+	for {
+		im, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+
+		p, err := enc.EncodeFrame(im)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil && p.Type != FrameTypeIDR {
+			sizes[p.Pts/10] += len(p.Bytes())
+		}
+	}
+
+	// frames 0-9 and 30-39 are outside the zones
+	for _, i := range []int{1, 2} {
+		if sizes[i]*2 > sizes[0] || sizes[i]*2 > sizes[3] {
+			t.Errorf("zone %d: %d bytes, outside %d and %d", i, sizes[i], sizes[0], sizes[3])
+		}
+	}
+}
+
+func TestZonesValidation(t *testing.T) {
+	for name, zone := range map[string]Zone{
+		"negative start":   {Start: -1, End: 10},
+		"end before start": {Start: 10, End: 5},
+		"qp out of range":  {Start: 0, End: 10, QP: 52},
+		"negative factor":  {Start: 0, End: 10, BitrateFactor: -1},
+	} {
+		opts := &Options{Width: 320, Height: 240, Zones: []Zone{zone}}
+		if _, err := NewEncoder(nil, opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEncodeX264Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		options map[string]string
+		bframes bool
+	}{
+		{name: "preset", bframes: true},
+		{name: "string", params: "bframes=0:ref=2:me=umh", bframes: false},
+		{name: "map", options: map[string]string{"bframes": "0", "no-cabac": ""}, bframes: false},
+		{name: "map after string", params: "bframes=0", options: map[string]string{"bframes": "3"}, bframes: true},
+	}
+
+	for _, test := range tests {
+		opts := &Options{
+			Width:       320,
+			Height:      240,
+			FrameRate:   25,
+			Preset:      "veryfast",
+			Profile:     "high",
+			X264Params:  test.params,
+			X264Options: test.options,
+		}
+
+		enc, err := NewEncoder(nil, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		bframes := false
+		check := func(p *Packet) {
+			if p != nil && (p.Type == FrameTypeB || p.Type == FrameTypeBref) {
+				bframes = true
+			}
+		}
+
+		src := newTestSource(opts.Width, opts.Height, 30)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			p, err := enc.EncodeFrame(im)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			check(p)
+		}
+		for {
+			p, err := enc.FlushFrame()
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if p == nil {
+				break
+			}
+			check(p)
+		}
+		enc.Close()
+
+		if bframes != test.bframes {
+			t.Errorf("%s: got B-frames %v, want %v", test.name, bframes, test.bframes)
+		}
+	}
+}
+
+func TestX264OptionsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		options map[string]string
+		err     OptionError
+	}{
+		{name: "unknown", params: "keyint=10:foo=1", err: OptionError{Name: "foo", Value: "1", Unknown: true}},
+		{name: "bad value", params: "me=fast", err: OptionError{Name: "me", Value: "fast"}},
+		{name: "bad number", options: map[string]string{"bframes": "x"}, err: OptionError{Name: "bframes", Value: "x"}},
+	}
+
+	for _, test := range tests {
+		opts := &Options{Width: 320, Height: 240, X264Params: test.params, X264Options: test.options}
+
+		_, err := NewEncoder(nil, opts)
+
+		var oe *OptionError
+		if !errors.As(err, &oe) {
+			t.Errorf("%s: got %v, want an OptionError", test.name, err)
+			continue
+		}
+		if *oe != test.err {
+			t.Errorf("%s: got %+v, want %+v", test.name, *oe, test.err)
+		}
+	}
+}
+
+func TestEncodeLogger(t *testing.T) {
+	var infoLog, noneLog, errLog bytes.Buffer
+
+	newEncoder := func(buf *bytes.Buffer, params string, level int32) (*Encoder, error) {
+		return NewEncoder(nil, &Options{
+			Width:      320,
+			Height:     240,
+			Preset:     "veryfast",
+			Profile:    "high",
+			LogLevel:   level,
+			Logger:     log.New(buf, "", 0),
+			X264Params: params,
+		})
+	}
+
+	info, err := newEncoder(&infoLog, "", LogInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := newEncoder(&noneLog, "", LogNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newEncoder(&errLog, "crop-rect=400,0,0,0", LogError); err == nil {
+		t.Error("invalid crop: expected an error")
+	}
+
+	for _, enc := range []*Encoder{info, none} {
+		src := newTestSource(320, 240, 5)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !strings.Contains(infoLog.String(), "x264 [info]: profile High") {
+		t.Errorf("info log: %q", infoLog.String())
+	}
+	// the stats are logged on close
+	if !strings.Contains(infoLog.String(), "x264 [info]: frame I:") {
+		t.Errorf("info log: %q", infoLog.String())
+	}
+	if noneLog.Len() != 0 {
+		t.Errorf("none log: %q", noneLog.String())
+	}
+	if !strings.HasPrefix(errLog.String(), "x264 [error]: ") || strings.Contains(errLog.String(), "[info]") {
+		t.Errorf("error log: %q", errLog.String())
+	}
+}
+
+func TestEncodeStats(t *testing.T) {
+	for _, quality := range []bool{false, true} {
+		var frames []FrameStats
+
+		opts := &Options{
+			Width:        320,
+			Height:       240,
+			FrameRate:    25,
+			Preset:       "veryfast",
+			Profile:      "high",
+			CRF:          23,
+			PSNR:         quality,
+			SSIM:         quality,
+			OnFrameStats: func(f FrameStats) { frames = append(frames, f) },
+		}
+
+		enc, err := NewEncoder(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := 0
+		src := newTestSource(opts.Width, opts.Height, 30)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			p, err := enc.EncodeFrame(im)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				size += len(p.Bytes())
+			}
+		}
+		for {
+			p, err := enc.FlushFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p == nil {
+				break
+			}
+			size += len(p.Bytes())
+		}
+
+		st := enc.Stats()
+		enc.Close()
+
+		if st.Frames != 30 || len(frames) != 30 {
+			t.Fatalf("got %d frames, %d callbacks", st.Frames, len(frames))
+		}
+		if st.Bytes != int64(size) || st.I.Bytes+st.P.Bytes+st.B.Bytes != int64(size) {
+			t.Errorf("got %d bytes (I %d, P %d, B %d), want %d", st.Bytes, st.I.Bytes, st.P.Bytes, st.B.Bytes, size)
+		}
+		if st.I.Frames < 1 || st.P.Frames < 1 || st.B.Frames < 1 || st.I.Frames+st.P.Frames+st.B.Frames != 30 {
+			t.Errorf("got I %d, P %d, B %d frames", st.I.Frames, st.P.Frames, st.B.Frames)
+		}
+		if st.Keyframes < 1 {
+			t.Errorf("got %d keyframes", st.Keyframes)
+		}
+		if st.QP <= 0 || st.QP > maxQP || st.I.QP <= 0 {
+			t.Errorf("got average QP %v, I-frame QP %v", st.QP, st.I.QP)
+		}
+		if math.Abs(st.CRF-23) > 1 {
+			t.Errorf("got average CRF %v", st.CRF)
+		}
+
+		if quality {
+			if st.PSNRAvg < 20 || st.PSNR[0] < 20 || st.SSIM < 0.5 || st.SSIM > 1 {
+				t.Errorf("got PSNR %v %v, SSIM %v", st.PSNRAvg, st.PSNR, st.SSIM)
+			}
+		} else if st.PSNRAvg != 0 || st.SSIM != 0 {
+			t.Errorf("got PSNR %v, SSIM %v without quality metrics", st.PSNRAvg, st.SSIM)
+		}
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	opts := &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Preset:    "veryfast",
+		Profile:   "high",
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	enc, err := NewEncoder(buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	src := newTestSource(opts.Width, opts.Height, 20)
+	for im, err := src.Next(); err == nil; im, err = src.Next() {
+		if err = enc.EncodeContext(ctx, im); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel()
+
+	if err = enc.EncodeContext(ctx, newTestSource(opts.Width, opts.Height, 1).img); !errors.Is(err, context.Canceled) {
+		t.Errorf("encode: got %v, want %v", err, context.Canceled)
+	}
+
+	delayed := int(encoderDelayedFrames(enc.e))
+	if delayed == 0 {
+		t.Fatal("no delayed frames")
+	}
+
+	err = enc.FlushContext(ctx)
+	var ce *CancelError
+	if !errors.As(err, &ce) || !errors.Is(err, context.Canceled) || ce.Abandoned != delayed {
+		t.Errorf("flush: got %v, want %d abandoned frames", err, delayed)
+	}
+
+	// the encoder is still usable, the rest is flushed on close
+	if err = enc.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := 0
+	for _, n := range splitNals(buf.Bytes()) {
+		if n.typ == int(NalSlice) || n.typ == int(NalSliceIdr) {
+			frames++
+		}
+	}
+	if frames != 20 {
+		t.Errorf("got %d frames, want 20", frames)
+	}
+}
+
+func TestEncodeClose(t *testing.T) {
+	open := atomic.LoadInt64(&openEncoders)
+
+	opts := testOptions()
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&openEncoders); n != open+1 {
+		t.Errorf("open encoders: got %d, want %d", n, open+1)
+	}
+
+	img := newTestSource(opts.Width, opts.Height, 1).img
+	if err = enc.Encode(img); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = enc.FlushFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.Encode(img); err != ErrFlushing {
+		t.Errorf("encode while flushing: got %v, want %v", err, ErrFlushing)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = enc.Close(); err != nil {
+			t.Fatalf("close %d: %v", i+1, err)
+		}
+	}
+	if err = enc.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&openEncoders); n != open {
+		t.Errorf("open encoders: got %d, want %d", n, open)
+	}
+
+	if err = enc.Encode(img); err != ErrClosed {
+		t.Errorf("encode: got %v, want %v", err, ErrClosed)
+	}
+	if _, err = enc.EncodeFrame(img); err != ErrClosed {
+		t.Errorf("encode frame: got %v, want %v", err, ErrClosed)
+	}
+	if err = enc.Flush(); err != ErrClosed {
+		t.Errorf("flush: got %v, want %v", err, ErrClosed)
+	}
+	if _, err = enc.FlushFrame(); err != ErrClosed {
+		t.Errorf("flush frame: got %v, want %v", err, ErrClosed)
+	}
+	if err = enc.SetCRF(30); err != ErrClosed {
+		t.Errorf("reconfigure: got %v, want %v", err, ErrClosed)
+	}
+	if enc.Headers() == nil {
+		t.Error("no headers after close")
+	}
+}
+
+func TestEncodeFlushOnClose(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		buf := bytes.NewBuffer(make([]byte, 0))
+
+		opts := testOptions()
+		opts.Tune = ""
+		opts.FlushOnClose = flush
+
+		enc, err := NewEncoder(buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := newTestSource(opts.Width, opts.Height, 10)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if encoderDelayedFrames(enc.e) == 0 {
+			t.Fatal("no delayed frames")
+		}
+
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		frames := 0
+		for _, n := range splitNals(buf.Bytes()) {
+			if n.typ == int(NalSlice) || n.typ == int(NalSliceIdr) {
+				frames++
+			}
+		}
+		if flush && frames != 10 {
+			t.Errorf("flush on close: got %d frames, want 10", frames)
+		}
+		if !flush && frames >= 10 {
+			t.Errorf("close without flush: got %d frames, want less than 10", frames)
+		}
+	}
+}
+
+func TestEncodeFinalizer(t *testing.T) {
+	open := atomic.LoadInt64(&openEncoders)
+
+	var buf bytes.Buffer
+	opts := testOptions()
+	opts.Logger = log.New(&buf, "", 0)
+
+	func() {
+		enc, err := NewEncoder(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.Encode(newTestSource(opts.Width, opts.Height, 1).img); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// finalizers run on their own goroutine after a collection
+	for i := 0; i < 50 && atomic.LoadInt64(&openEncoders) != open; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt64(&openEncoders); n != open {
+		t.Fatalf("leaked encoder was not closed: %d open, want %d", n, open)
+	}
+	if !strings.Contains(buf.String(), "encoder was not closed") {
+		t.Errorf("leak was not logged: %q", buf.String())
+	}
+}
+
+func TestEncodeColorspace(t *testing.T) {
+	tests := []struct {
+		cs      Colorspace
+		chroma  uint32
+		profile uint32
+	}{
+		{ColorspaceI420, 1, 100},
+		{ColorspaceI422, 2, 122},
+		{ColorspaceI444, 3, 244},
+		{ColorspaceNV12, 1, 100},
+		{ColorspaceNV21, 1, 100},
+		{ColorspaceBGR, 3, 244},
+		{ColorspaceBGRA, 3, 244},
+		{ColorspaceRGB, 3, 244},
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			rgba.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+		}
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		opts.Colorspace = test.cs
+
+		frame, err := newPicture(test.cs, 8, opts.Width, opts.Height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.fill(rgba)
+
+		// the frame is encoded in place, the image is converted into a frame of the colorspace
+		var out [2]bytes.Buffer
+		for k, im := range []image.Image{frame, rgba} {
+			enc, err := NewEncoder(&out[k], opts)
+			if err != nil {
+				t.Fatalf("%v: %v", test.cs, err)
+			}
+			for i := 0; i < 5; i++ {
+				if err = enc.Encode(im); err != nil {
+					t.Fatalf("%v: %v", test.cs, err)
+				}
+			}
+			if err = enc.CloseContext(context.Background()); err != nil {
+				t.Fatalf("%v: %v", test.cs, err)
+			}
+		}
+		frame.Free()
+
+		if !bytes.Equal(out[0].Bytes(), out[1].Bytes()) {
+			t.Errorf("%v: frame and image outputs differ", test.cs)
+		}
+
+		s, err := findSps(out[0].Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v", test.cs, err)
+		}
+		if s.chromaFormatIdc != test.chroma || s.profileIdc != test.profile {
+			t.Errorf("%v: got chroma_format_idc=%d profile_idc=%d, want %d, %d",
+				test.cs, s.chromaFormatIdc, s.profileIdc, test.chroma, test.profile)
+		}
+	}
+}
+
+func TestColorspaceProfile(t *testing.T) {
+	tests := []struct {
+		cs      Colorspace
+		depth   int
+		profile string
+		want    string
+	}{
+		{ColorspaceI420, 0, "baseline", "baseline"},
+		{ColorspaceNV12, 8, "main", "main"},
+		{ColorspaceI422, 0, "", ""},
+		{ColorspaceI422, 0, "high", "high422"},
+		{ColorspaceI422, 0, "high444", "high444"},
+		{ColorspaceI444, 0, "High10", "high444"},
+		{ColorspaceBGRA, 0, "baseline", "high444"},
+		{ColorspaceRGB, 0, "bogus", "bogus"},
+		{ColorspaceI420, 10, "high", "high10"},
+		{ColorspaceI420, 10, "high10", "high10"},
+		{ColorspaceI422, 10, "main", "high422"},
+		{ColorspaceI444, 10, "high444", "high444"},
+	}
+
+	for _, test := range tests {
+		opts := &Options{Colorspace: test.cs, BitDepth: test.depth, Profile: test.profile}
+		if got := opts.profile(); got != test.want {
+			t.Errorf("%v %d-bit %q: got %q, want %q", test.cs, test.depth, test.profile, got, test.want)
+		}
+	}
+
+	opts := testOptions()
+	opts.Colorspace = ColorspaceRGB + 1
+	if _, err := NewEncoder(nil, opts); err == nil {
+		t.Error("unknown colorspace: expected error")
+	}
+}
+
+func TestEncodeBitDepth(t *testing.T) {
+	opts := testOptions()
+	opts.BitDepth = 10
+
+	if lib := bitDepth(); lib != 0 && lib != 10 {
+		_, err := NewEncoder(nil, opts)
+		if !errors.Is(err, ErrBitDepth) {
+			t.Errorf("%d-bit library: got %v, want %v", lib, err, ErrBitDepth)
+		}
+		return
+	}
+
+	frame, err := NewFrame16(ColorspaceI420, 10, opts.Width, opts.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frame.Free()
+
+	img := newTestSource(opts.Width, opts.Height, 1).img
+	frame.fill(img)
+
+	var out [2]bytes.Buffer
+	for k, im := range []image.Image{frame, img} {
+		enc, err := NewEncoder(&out[k], opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(out[0].Bytes(), out[1].Bytes()) {
+		t.Error("frame and image outputs differ")
+	}
+
+	s, err := findSps(out[0].Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.bitDepthLuma != 10 || s.bitDepthChroma != 10 || s.profileIdc != 110 {
+		t.Errorf("got bit depth %d/%d profile_idc=%d, want 10/10, 110", s.bitDepthLuma, s.bitDepthChroma, s.profileIdc)
+	}
+}
+
+func TestBitDepthValidation(t *testing.T) {
+	for _, o := range []Options{
+		{BitDepth: 12},
+		{BitDepth: -1},
+		{BitDepth: 10, Colorspace: ColorspaceNV12},
+		{BitDepth: 10, Colorspace: ColorspaceBGRA},
+	} {
+		opts := testOptions()
+		opts.BitDepth, opts.Colorspace = o.BitDepth, o.Colorspace
+		if _, err := NewEncoder(nil, opts); err == nil || errors.Is(err, ErrBitDepth) {
+			t.Errorf("%d-bit %v: got %v, want a validation error", o.BitDepth, o.Colorspace, err)
+		}
+	}
+}
+
+func TestEncodeScale(t *testing.T) {
+	opts := testOptions()
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big := image.NewRGBA(image.Rect(0, 0, 640, 360))
+	var sizeErr *SizeError
+	if err = enc.Encode(big); !errors.As(err, &sizeErr) || sizeErr.Size != image.Pt(640, 360) || sizeErr.Want != image.Pt(320, 240) {
+		t.Errorf("got %v, want a size error", err)
+	}
+	if enc.frames != 0 || enc.fields != 0 {
+		t.Errorf("rejected image was counted")
+	}
+	enc.Close()
+
+	for _, mode := range []ScaleMode{ScaleStretch, ScaleFit, ScaleFill} {
+		var buf bytes.Buffer
+
+		opts := testOptions()
+		opts.ScaleMode = mode
+		enc, err := NewEncoder(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a window that changes size, white on red
+		for _, size := range []image.Point{{640, 360}, {100, 300}, {321, 241}, {320, 240}} {
+			img := image.NewRGBA(image.Rectangle{Max: size})
+			draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+			img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+
+			if err = enc.Encode(img.SubImage(img.Rect)); err != nil {
+				t.Fatalf("mode %d %v: %v", mode, size, err)
+			}
+		}
+
+		// empty images are rejected instead of scaled
+		empty := []image.Image{image.NewRGBA(image.Rectangle{}), image.NewYCbCr(image.Rect(0, 0, 0, 10), image.YCbCrSubsampleRatio420)}
+		for _, img := range empty {
+			if err = enc.Encode(img); !errors.As(err, &sizeErr) || sizeErr.Size != img.Bounds().Size() {
+				t.Errorf("mode %d %v: got %v, want a size error", mode, img.Bounds(), err)
+			}
+		}
+
+		// the last image has the frame size and is converted as is, the chroma is shared with white pixels
+		if y := enc.in.At(0, 0).(color.YCbCr).Y; y != 76 {
+			t.Errorf("mode %d: got luma %d at the origin, want red 76", mode, y)
+		}
+
+		// the 100x300 image is letterboxed with black bars on the sides
+		if mode == ScaleFit {
+			img := image.NewRGBA(image.Rect(0, 0, 100, 300))
+			draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+			enc.scale(img)
+			if y := enc.in.At(10, 120).(color.YCbCr).Y; y != 0 {
+				t.Errorf("fit: got luma %d in the bar, want black", y)
+			}
+			if y := enc.in.At(160, 120).(color.YCbCr).Y; y != 255 {
+				t.Errorf("fit: got luma %d in the image, want white", y)
+			}
+		}
+
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := findSps(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.width() != 320 || s.height() != 240 {
+			t.Errorf("mode %d: got %dx%d, want 320x240", mode, s.width(), s.height())
+		}
+	}
+
+	opts.ScaleMode = ScaleFill + 1
+	if _, err = NewEncoder(nil, opts); err == nil {
+		t.Error("unknown scale mode: expected error")
+	}
+}
+
+func TestEncodeResize(t *testing.T) {
+	var buf bytes.Buffer
+
+	opts := testOptions()
+	opts.Tune = ""
+	opts.RateControl = RateControlCRF
+	opts.CRF = 30
+
+	enc, err := NewEncoder(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	if err = enc.SetCRF(24); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newTestSource(320, 240, 5)
+	for im, err := src.Next(); err == nil; im, err = src.Next() {
+		if err = enc.Encode(im); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if encoderDelayedFrames(enc.e) == 0 {
+		t.Fatal("no delayed frames")
+	}
+	last := enc.pts
+
+	if err = enc.Resize(0, 240); err == nil {
+		t.Error("empty size: expected error")
+	}
+	if err = enc.Resize(640, 480); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Width != 320 {
+		t.Error("options were modified")
+	}
+	if p := enc.Params(); p.CRF != 24 {
+		t.Errorf("got CRF %v after resize, want 24", p.CRF)
+	}
+
+	// the old size is rejected, packets continue the timestamps
+	if err = enc.Encode(src.img); err == nil {
+		t.Error("old size: expected error")
+	}
+
+	src = newTestSource(640, 480, 5)
+	var pts []int64
+	for im, err := src.Next(); err == nil; im, err = src.Next() {
+		p, err := enc.EncodeFrame(im)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			pts = append(pts, p.Pts)
+		}
+	}
+	for p, err := enc.FlushFrame(); p != nil || err != nil; p, err = enc.FlushFrame() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pts = append(pts, p.Pts)
+		buf.Write(p.Bytes())
+	}
+
+	if len(pts) != 5 || pts[0] != last+1 {
+		t.Errorf("got pts %v after %d", pts, last)
+	}
+	if st := enc.Stats(); st.Frames != 10 {
+		t.Errorf("got %d frames in stats, want 10", st.Frames)
+	}
+
+	// the stream has the headers of both sizes, each followed by an IDR frame
+	var sizes []image.Point
+	idr := 0
+	sps := false
+	for _, n := range splitNals(buf.Bytes()) {
+		switch n.typ {
+		case int(NalSps):
+			s, err := parseSps(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, image.Pt(s.width(), s.height()))
+			sps = true
+		case int(NalSliceIdr):
+			if sps {
+				idr++
+			}
+			sps = false
+		case int(NalSlice):
+			sps = false
+		}
+	}
+	if len(sizes) < 2 || sizes[0] != image.Pt(320, 240) || sizes[len(sizes)-1] != image.Pt(640, 480) {
+		t.Errorf("got sizes %v", sizes)
+	}
+	if idr < 2 {
+		t.Errorf("got %d IDR frames after headers, want 2", idr)
+	}
+}
+
+func TestEncodeResizeDelayed(t *testing.T) {
+	opts := testOptions()
+	opts.Tune = ""
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	src := newTestSource(320, 240, 5)
+	var packets int
+	for im, err := src.Next(); err == nil; im, err = src.Next() {
+		p, err := enc.EncodeFrame(im)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			packets++
+		}
+	}
+
+	// without a writer the delayed frames would be dropped
+	if err = enc.Resize(640, 480); !errors.Is(err, ErrDelayedFrames) {
+		t.Fatalf("got %v, want %v", err, ErrDelayedFrames)
+	}
+	if enc.opts.Width != 320 {
+		t.Errorf("encoder was resized")
+	}
+
+	for p, err := enc.FlushFrame(); p != nil || err != nil; p, err = enc.FlushFrame() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets++
+	}
+	if packets != 5 {
+		t.Errorf("got %d packets, want 5", packets)
+	}
+
+	if err = enc.Resize(640, 480); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := enc.EncodeFrame(newTestSource(640, 480, 1).img); err != nil || enc.frames != 6 {
+		t.Errorf("got %v, %v after resize", p, err)
+	}
+}
+
+func TestEncodeSize(t *testing.T) {
+	tests := []struct {
+		cs            Colorspace
+		width, height int
+		crop          image.Rectangle
+		display       image.Rectangle
+	}{
+		{ColorspaceI420, 320, 240, image.Rectangle{}, image.Rect(0, 0, 320, 240)},
+		{ColorspaceI420, 100, 100, image.Rectangle{}, image.Rect(0, 0, 100, 100)},
+		{ColorspaceI420, 321, 241, image.Rectangle{}, image.Rect(0, 0, 322, 242)},
+		{ColorspaceI422, 321, 241, image.Rectangle{}, image.Rect(0, 0, 322, 241)},
+		{ColorspaceI444, 321, 241, image.Rectangle{}, image.Rect(0, 0, 321, 241)},
+		{ColorspaceI420, 320, 240, image.Rect(10, 8, 310, 232), image.Rect(10, 8, 310, 232)},
+		{ColorspaceNV12, 320, 240, image.Rect(11, 9, 309, 231), image.Rect(10, 8, 310, 232)},
+		{ColorspaceI444, 320, 240, image.Rect(11, 9, 309, 231), image.Rect(11, 9, 309, 231)},
+		{ColorspaceI420, 321, 241, image.Rect(1, 1, 321, 241), image.Rect(0, 0, 322, 242)},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		opts := testOptions()
+		opts.Colorspace = test.cs
+		opts.Width, opts.Height = test.width, test.height
+		opts.Crop = test.crop
+
+		enc, err := NewEncoder(&buf, opts)
+		if err != nil {
+			t.Fatalf("%v %dx%d: %v", test.cs, test.width, test.height, err)
+		}
+
+		src := newTestSource(test.width, test.height, 3)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// frames of the package are padded the same way
+		frame, err := newPicture(test.cs, 8, test.width, test.height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		draw.Draw(frame, frame.Bounds(), src.img, image.Point{}, draw.Src)
+		if err = enc.Encode(frame); err != nil {
+			t.Fatalf("%v %dx%d frame: %v", test.cs, test.width, test.height, err)
+		}
+		frame.Free()
+
+		// the padding repeats the last column and row
+		b := enc.in.Bounds()
+		if b.Dx() > test.width && enc.in.At(b.Dx()-1, 7) != enc.in.At(test.width-1, 7) {
+			t.Errorf("%v %dx%d: column padding differs", test.cs, test.width, test.height)
+		}
+		if b.Dy() > test.height && enc.in.At(7, b.Dy()-1) != enc.in.At(7, test.height-1) {
+			t.Errorf("%v %dx%d: row padding differs", test.cs, test.width, test.height)
+		}
+
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := findSps(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		unitX, unitY := test.cs.subsampling()
+		display := image.Rect(0, 0, s.width(), s.height()).Add(image.Pt(int(s.cropLeft)*unitX, int(s.cropTop)*unitY))
+		if display != test.display {
+			t.Errorf("%v %dx%d crop %v: got display %v, want %v",
+				test.cs, test.width, test.height, test.crop, display, test.display)
+		}
+	}
+}
+
+func TestCropValidation(t *testing.T) {
+	for _, crop := range []image.Rectangle{
+		image.Rect(0, 0, 321, 240),
+		image.Rect(-2, 0, 100, 100),
+		image.Rect(10, 10, 10, 20),
+	} {
+		opts := testOptions()
+		opts.Crop = crop
+		if _, err := NewEncoder(nil, opts); err == nil {
+			t.Errorf("crop %v: expected error", crop)
+		}
+	}
+}
+
+// picStructs returns the pic_struct of every pic timing SEI of a stream without HRD parameters.
+func picStructs(t *testing.T, b []byte) (ps []int) {
+	for _, n := range splitNals(b) {
+		if n.typ != 6 {
+			continue
+		}
+		msgs, err := parseSei(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgs {
+			if m.typ == 1 {
+				ps = append(ps, int(m.payload[0]>>4))
+			}
+		}
+	}
+	return
+}
+
+func TestEncodeInterlaced(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      FieldOrder
+		fake       bool
+		height     int
+		mbAdaptive bool
+		display    int
+		picStruct  int
+	}{
+		{"tff", FieldTopFirst, false, 240, true, 240, 3},
+		{"bff", FieldBottomFirst, false, 240, true, 240, 4},
+		{"tff padded", FieldTopFirst, false, 242, true, 244, 3},
+		{"fake", FieldProgressive, true, 240, false, 240, 0},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		opts := testOptions()
+		opts.Height = test.height
+		opts.FieldOrder = test.order
+		opts.FakeInterlaced = test.fake
+
+		enc, err := NewEncoder(&buf, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		src := newTestSource(320, test.height, 3)
+		for im, err := src.Next(); err == nil; im, err = src.Next() {
+			if err = enc.Encode(im); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := findSps(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.frameMbsOnly || s.mbAdaptive != test.mbAdaptive {
+			t.Errorf("%s: got frame_mbs_only=%v mb_adaptive=%v, want false, %v", test.name, s.frameMbsOnly, s.mbAdaptive, test.mbAdaptive)
+		}
+		if s.height() != test.display {
+			t.Errorf("%s: got height %d, want %d", test.name, s.height(), test.display)
+		}
+		if !s.vui.picStructPresent {
+			t.Errorf("%s: pic_struct is not signaled", test.name)
+		}
+
+		ps := picStructs(t, buf.Bytes())
+		if len(ps) != 3 || ps[0] != test.picStruct {
+			t.Errorf("%s: got pic_structs %v, want 3 of %d", test.name, ps, test.picStruct)
+		}
+	}
+}
+
+func TestEncodePulldown(t *testing.T) {
+	var buf bytes.Buffer
+
+	opts := testOptions()
+	opts.FrameRate, opts.FrameRateDen = 30000, 1001
+	opts.Pulldown = true
+
+	enc, err := NewEncoder(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3:2 pulldown of 24000/1001 film, five frame periods of 1001/30000 every four frames
+	pattern := []PicStruct{PicStructTopBottomTop, PicStructBottomTop, PicStructBottomTopBottom, PicStructTopBottom}
+	want := []int64{0, 2, 3, 4, 5, 7, 8, 9}
+
+	src := newTestSource(320, 240, len(want))
+	var pts []int64
+	for i := 0; i < len(want); i++ {
+		im, _ := src.Next()
+		if err = enc.EncodeWith(im, &EncodeOptions{PicStruct: pattern[i%len(pattern)]}); err != nil {
+			t.Fatal(err)
+		}
+		pts = append(pts, enc.pts)
+	}
+	if !reflect.DeepEqual(pts, want) {
+		t.Errorf("got pts %v, want %v", pts, want)
+	}
+
+	// a timestamp restarts the count, the frame after it follows its three fields
+	im := src.img
+	if err = enc.EncodeWith(im, &EncodeOptions{Pts: 20 * 1001 * time.Second / 30000, PicStruct: PicStructTopBottomTop}); err != nil {
+		t.Fatal(err)
+	}
+	if err = enc.EncodeWith(im, &EncodeOptions{PicStruct: PicStructBottomTop}); err != nil {
+		t.Fatal(err)
+	}
+	if enc.pts != 22 {
+		t.Errorf("got pts %d after a timestamp, want 22", enc.pts)
+	}
+
+	if err = enc.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := findSps(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.frameMbsOnly {
+		t.Error("progressive pulldown is coded as interlaced")
+	}
+	if v := s.vui; v.numUnitsInTick != 1001 || v.timeScale != 60000 || !v.picStructPresent {
+		t.Errorf("got num_units_in_tick=%d time_scale=%d pic_struct_present=%v, want 1001, 60000, true",
+			v.numUnitsInTick, v.timeScale, v.picStructPresent)
+	}
+
+	// H.264 Table D-1 values, TBT=5, BT=4, BTB=6, TB=3
+	if ps := picStructs(t, buf.Bytes()); !reflect.DeepEqual(ps, []int{5, 4, 6, 3, 5, 4, 6, 3, 5, 4}) {
+		t.Errorf("got pic_structs %v", ps)
+	}
+}
+
+func TestInterlaceValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*Options)
+		ok    bool
+	}{
+		{"unknown field order", func(o *Options) { o.FieldOrder = 3 }, false},
+		{"fake and interlaced", func(o *Options) { o.FieldOrder, o.FakeInterlaced = FieldTopFirst, true }, false},
+		{"pulldown vfr", func(o *Options) { o.Pulldown, o.VFRInput = true, true }, false},
+		{"pulldown timebase", func(o *Options) { o.Pulldown, o.TimebaseNum, o.TimebaseDen = true, 1, 90000 }, false},
+		{"pulldown frame timebase", func(o *Options) { o.Pulldown, o.TimebaseNum, o.TimebaseDen = true, 1, 25 }, true},
+		{"bff", func(o *Options) { o.FieldOrder = FieldBottomFirst }, true},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		test.setup(opts)
+
+		enc, err := NewEncoder(nil, opts)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if enc != nil && err == nil {
+			enc.Close()
+		}
+	}
+
+	frames := []struct {
+		name  string
+		setup func(*Options)
+		ps    PicStruct
+	}{
+		{"progressive field order", func(o *Options) {}, PicStructTopBottom},
+		{"interlaced repeat", func(o *Options) { o.FieldOrder = FieldTopFirst }, PicStructTopBottomTop},
+		{"fake triple", func(o *Options) { o.FakeInterlaced = true }, PicStructTriple},
+		{"unknown", func(o *Options) { o.Pulldown = true }, PicStruct(12)},
+	}
+
+	for _, test := range frames {
+		opts := testOptions()
+		test.setup(opts)
+
+		enc, err := NewEncoder(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = enc.EncodeWith(image.NewRGBA(image.Rect(0, 0, 320, 240)), &EncodeOptions{PicStruct: test.ps}); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if enc.frames != 0 || enc.fields != 0 {
+			t.Errorf("%s: rejected frame was counted", test.name)
+		}
+		enc.Close()
+	}
+}
+
+func TestEncodeColor(t *testing.T) {
+	tests := []struct {
+		name      string
+		cs        Colorspace
+		color     Color
+		full      bool
+		primaries uint32
+		transfer  uint32
+		matrix    uint32
+		chromaLoc uint32
+		// luma of red converted into the frame
+		red uint8
+	}{
+		{"default", ColorspaceI420, Color{}, true, 2, 2, 6, 0, 76},
+		{"bt709", ColorspaceI420, Color{Primaries: PrimariesBT709, Transfer: TransferBT709, Matrix: MatrixBT709, Range: RangeLimited}, false, 1, 1, 1, 0, 63},
+		{"bt2020 pq", ColorspaceI420, Color{Primaries: PrimariesBT2020, Transfer: TransferPQ, Matrix: MatrixBT2020, ChromaLocation: ChromaTopLeft}, true, 9, 16, 9, 2, 67},
+		{"unspecified", ColorspaceI444, Color{Matrix: MatrixUnspecified, Range: RangeLimited}, false, 2, 2, 2, 0, 81},
+		{"rgb", ColorspaceRGB, Color{Primaries: PrimariesBT709}, true, 1, 2, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		opts := testOptions()
+		opts.Colorspace = test.cs
+		opts.Color = test.color
+
+		enc, err := NewEncoder(&buf, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		im := image.NewRGBA(image.Rect(0, 0, 320, 240))
+		draw.Draw(im, im.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+		if err = enc.Encode(im); err != nil {
+			t.Fatal(err)
+		}
+		if ycc, ok := enc.in.At(0, 0).(color.YCbCr); ok && ycc.Y != test.red {
+			t.Errorf("%s: got red luma %d, want %d", test.name, ycc.Y, test.red)
+		}
+
+		// Go YCbCr images are JFIF, they are converted like the other images
+		jfif := image.NewYCbCr(im.Rect, image.YCbCrSubsampleRatio420)
+		red := color.YCbCrModel.Convert(color.RGBA{255, 0, 0, 255}).(color.YCbCr)
+		for i := range jfif.Y {
+			jfif.Y[i] = red.Y
+		}
+		for i := range jfif.Cb {
+			jfif.Cb[i], jfif.Cr[i] = red.Cb, red.Cr
+		}
+		if err = enc.Encode(jfif); err != nil {
+			t.Fatal(err)
+		}
+		if ycc, ok := enc.in.At(0, 0).(color.YCbCr); ok && (ycc.Y < test.red-1 || ycc.Y > test.red+1) {
+			t.Errorf("%s: got JFIF red luma %d, want %d", test.name, ycc.Y, test.red)
+		}
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := findSps(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// x264 leaves out the description when it is all unspecified
+		v := s.vui
+		if !v.colourDescPresent {
+			v.colourPrimaries, v.transfer, v.matrix = 2, 2, 2
+		}
+		if v.fullRange != test.full || v.colourPrimaries != test.primaries || v.transfer != test.transfer || v.matrix != test.matrix {
+			t.Errorf("%s: got full=%v primaries=%d transfer=%d matrix=%d, want %v, %d, %d, %d", test.name,
+				v.fullRange, v.colourPrimaries, v.transfer, v.matrix, test.full, test.primaries, test.transfer, test.matrix)
+		}
+		if v.chromaLocPresent != (test.chromaLoc != 0) || v.chromaLocTop != test.chromaLoc {
+			t.Errorf("%s: got chroma location %v %d, want %d", test.name, v.chromaLocPresent, v.chromaLocTop, test.chromaLoc)
+		}
+	}
+}
+
+func TestEncodeColorPad(t *testing.T) {
+	opts := testOptions()
+	opts.Width, opts.Height = 33, 16
+	opts.Color = Color{Matrix: MatrixBT709, Range: RangeLimited}
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// the last column is red, its chroma is shared with the padding
+	im := image.NewRGBA(image.Rect(0, 0, 33, 16))
+	draw.Draw(im, image.Rect(32, 0, 33, 16), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	if _, err = enc.EncodeFrame(im); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, x := range []int{32, 33} {
+		if got, want := enc.in.At(x, 5), (color.YCbCr{Y: 63, Cb: 102, Cr: 240}); got != want {
+			t.Errorf("column %d: got %v, want limited BT.709 red %v", x, got, want)
+		}
+	}
+}
+
+func TestColorValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		cs    Colorspace
+		color Color
+	}{
+		{"primaries", ColorspaceI420, Color{Primaries: 20}},
+		{"transfer", ColorspaceI420, Color{Transfer: -1}},
+		{"matrix", ColorspaceI420, Color{Matrix: 20}},
+		{"range", ColorspaceI420, Color{Range: 2}},
+		{"chroma location", ColorspaceI420, Color{ChromaLocation: 6}},
+		{"gbr yuv", ColorspaceI444, Color{Matrix: MatrixGBR}},
+		{"rgb matrix", ColorspaceBGR, Color{Matrix: MatrixBT709}},
+		{"rgb range", ColorspaceBGRA, Color{Range: RangeLimited}},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		opts.Colorspace = test.cs
+		opts.Color = test.color
+		if _, err := NewEncoder(nil, opts); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestEncodeAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		crop          image.Rectangle
+		sar, dar      AspectRatio
+		overscan      Overscan
+		sarW, sarH    uint32
+	}{
+		{"unset", 320, 240, image.Rectangle{}, AspectRatio{}, AspectRatio{}, OverscanUnspecified, 0, 0},
+		{"sar table", 320, 240, image.Rectangle{}, AspectRatio{16, 11}, AspectRatio{}, OverscanShow, 16, 11},
+		{"sar reduced", 320, 240, image.Rectangle{}, AspectRatio{64, 45}, AspectRatio{}, OverscanCrop, 64, 45},
+		{"sar extended", 320, 240, image.Rectangle{}, AspectRatio{20, 18}, AspectRatio{}, OverscanUnspecified, 10, 9},
+		{"ntsc 16:9", 720, 480, image.Rectangle{}, AspectRatio{}, AspectRatio{16, 9}, OverscanUnspecified, 32, 27},
+		{"pal 4:3", 720, 576, image.Rectangle{}, AspectRatio{}, AspectRatio{4, 3}, OverscanUnspecified, 16, 15},
+		{"square", 640, 360, image.Rectangle{}, AspectRatio{}, AspectRatio{16, 9}, OverscanUnspecified, 1, 1},
+		// the displayed part after cropping is 704x480
+		{"cropped", 720, 480, image.Rect(8, 0, 712, 480), AspectRatio{}, AspectRatio{4, 3}, OverscanUnspecified, 10, 11},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		opts.Width, opts.Height = test.width, test.height
+		opts.Crop = test.crop
+		opts.SampleAspectRatio = test.sar
+		opts.DisplayAspectRatio = test.dar
+		opts.Overscan = test.overscan
+
+		s, err := findSps(encodeFrames(t, opts, 2))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		v := s.vui
+		if w, h := v.sar(); w != test.sarW || h != test.sarH {
+			t.Errorf("%s: got SAR %d:%d, want %d:%d", test.name, w, h, test.sarW, test.sarH)
+		}
+		if v.overscanInfoPresent != (test.overscan != OverscanUnspecified) || v.overscanAppropriate != (test.overscan == OverscanCrop) {
+			t.Errorf("%s: got overscan present=%v appropriate=%v, want %v", test.name, v.overscanInfoPresent, v.overscanAppropriate, test.overscan)
+		}
+	}
+}
+
+func TestAspectRatioValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*Options)
+	}{
+		{"negative", func(o *Options) { o.SampleAspectRatio = AspectRatio{-1, 1} }},
+		{"half", func(o *Options) { o.DisplayAspectRatio = AspectRatio{16, 0} }},
+		{"both", func(o *Options) { o.SampleAspectRatio, o.DisplayAspectRatio = AspectRatio{1, 1}, AspectRatio{16, 9} }},
+		{"too big", func(o *Options) { o.SampleAspectRatio = AspectRatio{65537, 2} }},
+		{"overscan", func(o *Options) { o.Overscan = 3 }},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		test.setup(opts)
+		if _, err := NewEncoder(nil, opts); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestEncodeSEI(t *testing.T) {
+	uuid := [16]byte{0x6e, 0x84, 0x6d, 0x0a, 0x1f, 0x2b, 0x4c, 0x9e, 0x8a, 0x2e, 0x55, 0x3a, 0x0f, 0x17, 0x99, 0x01}
+
+	// without and with delayed frames, the messages follow their frame
+	for _, tune := range []string{"zerolatency", ""} {
+		var buf bytes.Buffer
+
+		opts := testOptions()
+		opts.Tune = tune
+
+		enc, err := NewEncoder(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const frames = 6
+		src := newTestSource(320, 240, frames)
+		for i := 0; i < frames; i++ {
+			im, _ := src.Next()
+			sei := []SEIMessage{
+				UserDataUnregistered(uuid, []byte(fmt.Sprintf("frame %d", i))),
+				// emulation prevention is added by x264
+				UserDataRegistered(0xb5, []byte{0x00, 0x31, 0x00, 0x00, 0x03, byte(i)}),
+			}
+			if err = enc.EncodeWith(im, &EncodeOptions{SEI: sei}); err != nil {
+				t.Fatal(err)
+			}
+			// the payloads were copied
+			sei[0].Payload[16] = 'x'
+		}
+		if err = enc.CloseContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var user []string
+		var t35 [][]byte
+		for _, n := range splitNals(buf.Bytes()) {
+			if n.typ != 6 {
+				continue
+			}
+			msgs, err := parseSei(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range msgs {
+				switch {
+				case m.typ == SEIUserDataUnregistered && bytes.HasPrefix(m.payload, uuid[:]):
+					user = append(user, string(m.payload[16:]))
+				case m.typ == SEIUserDataRegistered:
+					t35 = append(t35, m.payload)
+				}
+			}
+		}
+
+		if len(user) != frames || len(t35) != frames {
+			t.Fatalf("tune %q: got %d unregistered and %d registered messages, want %d", tune, len(user), len(t35), frames)
+		}
+		// in decode order, the messages of a frame are in the same access unit
+		seen := make(map[int]bool)
+		for i := range user {
+			var n int
+			if _, err := fmt.Sscanf(user[i], "frame %d", &n); err != nil || seen[n] {
+				t.Errorf("tune %q: got message %q", tune, user[i])
+				continue
+			}
+			seen[n] = true
+			if want := []byte{0xb5, 0x00, 0x31, 0x00, 0x00, 0x03, byte(n)}; !bytes.Equal(t35[i], want) {
+				t.Errorf("tune %q: got T.35 payload %x with %q, want %x", tune, t35[i], user[i], want)
+			}
+		}
+	}
+}
+
+func TestEncodeSEIDropped(t *testing.T) {
+	opts := testOptions()
+	opts.Tune = ""
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := newTestSource(320, 240, 5)
+	for im, err := src.Next(); err == nil; im, err = src.Next() {
+		sei := []SEIMessage{UserDataUnregistered([16]byte{1}, make([]byte, 1000))}
+		if err = enc.EncodeWith(im, &EncodeOptions{SEI: sei}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if encoderDelayedFrames(enc.e) == 0 {
+		t.Fatal("no delayed frames")
+	}
+
+	// the messages of delayed frames are freed with them
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSEIValidation(t *testing.T) {
+	enc, err := NewEncoder(nil, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	im := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for _, m := range []SEIMessage{
+		{Type: -1, Payload: []byte{1}},
+		{Type: SEIUserDataUnregistered, Payload: []byte("short")},
+		{Type: SEIUserDataRegistered},
+	} {
+		if err = enc.EncodeWith(im, &EncodeOptions{SEI: []SEIMessage{m}}); err == nil {
+			t.Errorf("type %d payload %q: expected error", m.Type, m.Payload)
+		}
+	}
+	if enc.frames != 0 {
+		t.Error("rejected frame was counted")
+	}
+}
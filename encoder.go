@@ -0,0 +1,797 @@
+// Package x264 provides H.264/MPEG-4 AVC codec encoder based on [x264](https://www.videolan.org/developers/x264.html) library.
+package x264
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// state is the lifecycle state of an encoder.
+type state int
+
+// Encoder states.
+const (
+	// Frames are accepted.
+	stateOpen state = iota
+	// Delayed frames are being flushed, no more frames are accepted.
+	stateFlushing
+	// The x264 encoder is freed.
+	stateClosed
+)
+
+// openEncoders is the number of encoders that were not closed yet, accessed atomically.
+var openEncoders int64
+
+// Encoder type.
+type Encoder struct {
+	e *x264T
+	w io.Writer
+
+	opts *Options
+
+	state state
+
+	csp int32
+	// pts of the last submitted frame, -1 before the first one
+	pts int64
+
+	// fields displayed since basePts, pts of frames without a timestamp is counted from it
+	basePts int64
+	fields  int64
+	// frames submitted in total
+	frames int64
+
+	nnals int32
+	nals  []*x264Nal
+
+	// input frame for images that are not frames of the encoder size and colorspace
+	in picture
+	// resamples images of another size into the input frame
+	scaler scaler
+	// kept apart from Go pointers, so they can be passed to C
+	picIn, picOut *x264Picture
+
+	headers *Packet
+
+	// force an IDR frame on the next encode
+	forceIDR bool
+
+	// rate-control parameters, see Reconfigure
+	params Params
+
+	// rate-control zones in C memory
+	zones []x264Zone
+
+	// id of the log callback, zero without a logger
+	logID uintptr
+
+	stats statsSum
+
+	// timebase
+	tbNum, tbDen int64
+	// ticks per frame as a fraction
+	tpfNum, tpfDen int64
+}
+
+// NewEncoder returns new x264 encoder.
+// Headers and encoded frames are written to w, which can be nil when only packets are used.
+func NewEncoder(w io.Writer, opts *Options) (e *Encoder, err error) {
+	e = &Encoder{}
+
+	e.w = w
+	e.pts = -1
+	e.opts = opts
+
+	e.nals = make([]*x264Nal, 3)
+
+	if err = e.opts.validateSize(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateTiming(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateColorspace(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateColor(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateScale(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateInterlace(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateCrop(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateAspect(); err != nil {
+		return
+	}
+	e.scaler.filter = e.opts.ScaleFilter
+	e.csp = e.opts.Colorspace.csp()
+	if e.opts.bitDepth() > 8 {
+		e.csp |= cspHighDepth
+	}
+
+	if err = e.opts.validateRateControl(); err != nil {
+		return
+	}
+
+	if err = e.opts.validateZones(); err != nil {
+		return
+	}
+
+	param := x264Param{}
+
+	if e.opts.Preset != "" && e.opts.Profile != "" {
+		ret := paramDefaultPreset(&param, e.opts.Preset, e.opts.Tune)
+		if ret < 0 {
+			err = ErrInvalidPreset
+			return
+		}
+	} else {
+		paramDefault(&param)
+	}
+
+	param.ICsp = e.csp
+	param.BVfrInput = 0
+	if e.opts.VFRInput {
+		param.BVfrInput = 1
+	}
+	param.BRepeatHeaders = 1
+	param.BAnnexb = 1
+	param.ILogLevel = e.opts.LogLevel
+	if e.opts.PSNR {
+		param.Analyse.BPsnr = 1
+	}
+	if e.opts.SSIM {
+		param.Analyse.BSsim = 1
+	}
+
+	setupParam(&param, e.opts)
+
+	applyCrop(&param, e.opts)
+
+	applyColor(&param, e.opts)
+
+	applyAspect(&param, e.opts)
+
+	applyTiming(&param, e.opts)
+
+	applyInterlace(&param, e.opts)
+
+	applyRateControl(&param, e.opts)
+
+	if err = applyOptions(&param, e.opts); err != nil {
+		return
+	}
+
+	if e.opts.pass > 0 {
+		if err = applyPass(&param, e.opts.pass, e.opts.stats); err != nil {
+			return
+		}
+	}
+
+	e.tbNum, e.tbDen = int64(param.ITimebaseNum), int64(param.ITimebaseDen)
+	e.tpfNum = e.tbDen * int64(param.IFpsDen)
+	e.tpfDen = e.tbNum * int64(param.IFpsNum)
+
+	if profile := e.opts.profile(); profile != "" {
+		ret := paramApplyProfile(&param, profile)
+		if ret < 0 {
+			err = ErrInvalidProfile
+			return
+		}
+	}
+
+	// Allocate on create instead while encoding
+	size := e.opts.frameSize()
+	if e.in, err = newPicture(e.opts.Colorspace, e.opts.bitDepth(), size.X, size.Y); err != nil {
+		return
+	}
+	setModel(e.in, e.opts.Color.model())
+	defer func() {
+		// Cleanup if intialization fail
+		if err != nil {
+			if e.e != nil {
+				encoderClose(e.e)
+			}
+			e.in.Free()
+			zonesFree(e.zones)
+			if e.logID != 0 {
+				logFree(e.logID)
+			}
+		}
+	}()
+
+	if e.opts.PSNR || e.opts.SSIM {
+		// x264 computes the metrics only when logging info messages, the extra messages are dropped
+		if param.ILogLevel < LogInfo {
+			param.ILogLevel = LogInfo
+		}
+	}
+	if e.opts.Logger != nil || param.ILogLevel != e.opts.LogLevel {
+		l := e.opts.Logger
+		if l == nil {
+			l = log.New(os.Stderr, "", 0)
+		}
+		e.logID = logSet(&param, logger(l, e.opts.LogLevel))
+	}
+
+	if len(e.opts.Zones) > 0 {
+		// x264 keeps the pointer in its parameters, so the zones live as long as the encoder
+		if e.zones = zonesAlloc(len(e.opts.Zones)); e.zones == nil {
+			err = ErrAlloc
+			return
+		}
+		applyZones(&param, e.zones, e.opts.Zones)
+	}
+
+	e.picIn, e.picOut = &x264Picture{}, &x264Picture{}
+
+	e.e = encoderOpen(&param)
+	if e.e == nil {
+		err = ErrOpen
+		return
+	}
+
+	// X264Params and x264 itself can change the rate control of the options
+	encoderParameters(e.e, &param)
+	e.params = rateParams(&param)
+
+	ret := encoderHeaders(e.e, e.nals, &e.nnals)
+	if ret < 0 {
+		err = ErrHeaders
+		return
+	}
+
+	e.headers = e.packet(ret, nil)
+
+	if e.headers != nil {
+		if err = e.write(e.headers.data); err != nil {
+			return
+		}
+	}
+
+	atomic.AddInt64(&openEncoders, 1)
+	runtime.SetFinalizer(e, (*Encoder).finalize)
+
+	return
+}
+
+// applyTiming sets frame rate, keyframe interval and timebase.
+func applyTiming(param *x264Param, opts *Options) {
+	if fpsNum, fpsDen := opts.frameRate(); fpsNum > 0 {
+		param.IFpsNum = uint32(fpsNum)
+		param.IFpsDen = uint32(fpsDen)
+	}
+
+	param.IKeyintMax = int32((param.IFpsNum + param.IFpsDen - 1) / param.IFpsDen)
+	if opts.KeyintMax > 0 {
+		param.IKeyintMax = int32(opts.KeyintMax)
+	}
+	param.IKeyintMin = keyintMinAuto
+	if opts.KeyintMin > 0 {
+		param.IKeyintMin = int32(opts.KeyintMin)
+	}
+	param.BIntraRefresh = 1
+
+	param.ITimebaseNum = param.IFpsDen
+	param.ITimebaseDen = param.IFpsNum
+	if opts.TimebaseNum > 0 {
+		param.ITimebaseNum = uint32(opts.TimebaseNum)
+		param.ITimebaseDen = uint32(opts.TimebaseDen)
+	} else if opts.VFRInput {
+		param.ITimebaseNum = 1
+		param.ITimebaseDen = vfrTimebaseDen
+	}
+}
+
+// applyRateControl maps rate-control options onto the encoder parameters.
+func applyRateControl(param *x264Param, opts *Options) {
+	switch opts.RateControl {
+	case RateControlCRF:
+		param.Rc.IRcMethod = rcCrf
+		param.Rc.FRfConstant = defaultCRF
+		if opts.CRF > 0 {
+			param.Rc.FRfConstant = opts.CRF
+		}
+	case RateControlCQP:
+		param.Rc.IRcMethod = rcCqp
+		param.Rc.IQpConstant = int32(opts.QP)
+	case RateControlABR:
+		param.Rc.IRcMethod = rcAbr
+		param.Rc.IBitrate = int32(opts.Bitrate)
+	case RateControlCBR:
+		param.Rc.IRcMethod = rcAbr
+		param.Rc.IBitrate = int32(opts.Bitrate)
+		param.Rc.IVbvMaxBitrate = int32(opts.Bitrate)
+		param.Rc.IVbvBufferSize = int32(opts.Bitrate)
+	}
+
+	if opts.VBVMaxBitrate > 0 {
+		param.Rc.IVbvMaxBitrate = int32(opts.VBVMaxBitrate)
+	}
+	if opts.VBVBufferSize > 0 {
+		param.Rc.IVbvBufferSize = int32(opts.VBVBufferSize)
+	}
+	if opts.VBVInit > 0 {
+		param.Rc.FVbvBufferInit = opts.VBVInit
+	}
+}
+
+// applyOptions applies raw x264 options.
+func applyOptions(param *x264Param, opts *Options) error {
+	names, values := opts.x264Options()
+	for i, name := range names {
+		switch paramParse(param, name, values[i]) {
+		case 0:
+		case paramBadName:
+			return &OptionError{Name: name, Value: values[i], Unknown: true}
+		default:
+			return &OptionError{Name: name, Value: values[i]}
+		}
+	}
+
+	return nil
+}
+
+// logger returns a log callback writing x264 messages up to max to l with the x264 level prefix.
+func logger(l *log.Logger, max int32) func(level int32, msg string) {
+	return func(level int32, msg string) {
+		if level > max {
+			return
+		}
+
+		name := "unknown"
+		switch level {
+		case LogError:
+			name = "error"
+		case LogWarning:
+			name = "warning"
+		case LogInfo:
+			name = "info"
+		case LogDebug:
+			name = "debug"
+		}
+
+		l.Printf("x264 [%s]: %s", name, strings.TrimRight(msg, "\n"))
+	}
+}
+
+// applyZones copies rate-control zones to C memory and sets them in the parameters.
+func applyZones(param *x264Param, czones []x264Zone, zones []Zone) {
+	for i, z := range zones {
+		czones[i].IStart = int32(z.Start)
+		czones[i].IEnd = int32(z.End)
+		if z.BitrateFactor > 0 {
+			czones[i].FBitrateFactor = z.BitrateFactor
+		} else {
+			czones[i].BForceQp = 1
+			czones[i].IQp = int32(z.QP)
+		}
+	}
+
+	param.Rc.Zones = &czones[0]
+	param.Rc.IZones = int32(len(czones))
+}
+
+// Encode encodes image and writes the output to the writer.
+// Frames of the encoder size and colorspace are encoded in place, other images are converted to it first.
+// Images of another size are scaled according to Options.ScaleMode.
+func (e *Encoder) Encode(im image.Image) error {
+	return e.EncodeWith(im, nil)
+}
+
+// EncodeAt encodes image presented at pts and writes the output to the writer.
+func (e *Encoder) EncodeAt(im image.Image, pts time.Duration) error {
+	ret, err := e.encode(im, &EncodeOptions{Pts: pts}, true)
+	if err != nil {
+		return err
+	}
+
+	return e.write(e.payload(ret))
+}
+
+// EncodeWith encodes image with per-frame options and writes the output to the writer.
+func (e *Encoder) EncodeWith(im image.Image, opts *EncodeOptions) error {
+	ret, err := e.encode(im, opts, opts != nil && opts.Pts > 0)
+	if err != nil {
+		return err
+	}
+
+	return e.write(e.payload(ret))
+}
+
+// EncodeFrame encodes image and returns the encoded access unit.
+// The packet is nil while x264 buffers frames for lookahead or B-frames,
+// these frames are returned later by EncodeFrame or FlushFrame.
+func (e *Encoder) EncodeFrame(im image.Image) (*Packet, error) {
+	return e.EncodeFrameWith(im, nil)
+}
+
+// EncodeFrameAt is EncodeFrame with a caller-supplied presentation time.
+// It requires Options.VFRInput or Options.Pulldown, otherwise ErrNotVFR is returned.
+// The time is converted to timebase ticks and must increase with every frame,
+// otherwise a *TimestampError is returned, negative or too big times return ErrTimestamp.
+// Frames encoded later without a time continue from the last one at the configured frame rate.
+func (e *Encoder) EncodeFrameAt(im image.Image, pts time.Duration) (*Packet, error) {
+	return e.encodeFrame(im, &EncodeOptions{Pts: pts}, true)
+}
+
+// EncodeFrameWith is EncodeFrame with per-frame options.
+func (e *Encoder) EncodeFrameWith(im image.Image, opts *EncodeOptions) (*Packet, error) {
+	return e.encodeFrame(im, opts, opts != nil && opts.Pts > 0)
+}
+
+// encodeFrame encodes image with the frame options and returns the encoded access unit.
+func (e *Encoder) encodeFrame(im image.Image, opts *EncodeOptions, timed bool) (*Packet, error) {
+	ret, err := e.encode(im, opts, timed)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.packet(ret, e.picOut), nil
+}
+
+// ForceKeyframe makes the next encoded frame an IDR frame.
+func (e *Encoder) ForceKeyframe() {
+	e.forceIDR = true
+}
+
+// encode encodes image with the frame options, it returns the size of the output NAL units.
+// The pts of the options is used when timed is set.
+func (e *Encoder) encode(im image.Image, opts *EncodeOptions, timed bool) (int32, error) {
+	if e.state == stateClosed {
+		return 0, ErrClosed
+	}
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+
+	f, direct := im.(picture)
+	direct = direct && f.cpic() != nil && f.cpic().Img.ICsp == e.csp && f.Bounds().Eq(e.in.Bounds()) &&
+		pictureDepth(f) == e.opts.bitDepth()
+
+	size, want := im.Bounds().Size(), image.Pt(e.opts.Width, e.opts.Height)
+	if !direct && size != want && (e.opts.ScaleMode == ScaleReject || im.Bounds().Empty()) {
+		// empty images can't be scaled
+		return 0, &SizeError{Size: size, Want: want}
+	}
+
+	if err := e.opts.validatePicStruct(opts.PicStruct); err != nil {
+		return 0, err
+	}
+
+	if err := validateSEI(opts.SEI); err != nil {
+		return 0, err
+	}
+
+	// the timing is kept only when x264 accepts the frame
+	var pts, base, fields int64
+	if timed {
+		ticks, err := e.timestamp(opts.Pts)
+		if err != nil {
+			return 0, err
+		}
+		pts, base, fields = ticks, ticks, opts.PicStruct.fields()
+	} else {
+		pts, fields = e.nextPts(opts.PicStruct)
+		base = e.basePts
+	}
+
+	// a non-monotonic timestamp is reported before the flushing state
+	if e.state == stateFlushing {
+		return 0, ErrFlushing
+	}
+
+	if !direct {
+		if size == want {
+			e.in.fill(im)
+		} else {
+			e.scale(im)
+		}
+		e.pad()
+		f = e.in
+	}
+
+	*e.picIn = *f.cpic()
+	// messages are set on the copy, x264 owns them once the picture is submitted
+	if err := e.setSEI(opts.SEI); err != nil {
+		return 0, err
+	}
+	e.picIn.IPts = pts
+	e.picIn.IPicStruct = opts.PicStruct.picStruct()
+
+	e.picIn.IType = typeAuto
+	if opts.ForceIDR || e.forceIDR {
+		e.picIn.IType = typeIdr
+	}
+
+	ret := encoderEncode(e.e, e.nals, &e.nnals, e.picIn, e.picOut)
+	if ret < 0 {
+		return 0, &EncodeError{Frame: e.frames, Code: ret}
+	}
+	e.pts, e.basePts, e.fields = pts, base, fields
+	e.frames++
+	if e.picIn.IType == typeIdr {
+		e.forceIDR = false
+	}
+	e.collect(ret)
+
+	return ret, nil
+}
+
+// scale resamples the image into the input frame according to the scale mode.
+func (e *Encoder) scale(im image.Image) {
+	frame := image.Rect(0, 0, e.opts.Width, e.opts.Height)
+	sr, dr := scaleRects(e.opts.ScaleMode, im.Bounds(), frame)
+
+	if dr != frame {
+		// letterbox bars above, below, left and right of the image
+		for _, r := range []image.Rectangle{
+			image.Rect(frame.Min.X, frame.Min.Y, frame.Max.X, dr.Min.Y),
+			image.Rect(frame.Min.X, dr.Max.Y, frame.Max.X, frame.Max.Y),
+			image.Rect(frame.Min.X, dr.Min.Y, dr.Min.X, dr.Max.Y),
+			image.Rect(dr.Max.X, dr.Min.Y, frame.Max.X, dr.Max.Y),
+		} {
+			draw.Draw(e.in, r, image.Black, image.Point{}, draw.Src)
+		}
+	}
+
+	e.scaler.scale(e.in, dr, im, sr)
+}
+
+// EncodeContext is Encode that doesn't encode the image when ctx is done and returns ctx.Err().
+func (e *Encoder) EncodeContext(ctx context.Context, im image.Image) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.Encode(im)
+}
+
+// Flush flushes encoder and writes delayed frames to the writer.
+// No more frames can be encoded once flushing has begun.
+func (e *Encoder) Flush() error {
+	return e.FlushContext(context.Background())
+}
+
+// FlushContext is Flush that stops after the current frame when ctx is done.
+// It then returns a *CancelError with the number of delayed frames left in the encoder,
+// they can be flushed by a later call.
+func (e *Encoder) FlushContext(ctx context.Context) error {
+	if e.state == stateClosed {
+		return ErrClosed
+	}
+	e.state = stateFlushing
+
+	for encoderDelayedFrames(e.e) > 0 {
+		if err := ctx.Err(); err != nil {
+			return &CancelError{Abandoned: int(encoderDelayedFrames(e.e)), Err: err}
+		}
+
+		ret := encoderEncode(e.e, e.nals, &e.nnals, nil, e.picOut)
+		if ret < 0 {
+			return &EncodeError{Frame: -1, Code: ret}
+		}
+		e.collect(ret)
+
+		if err := e.write(e.payload(ret)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FlushFrame returns the next delayed access unit, nil when there are no delayed frames left.
+func (e *Encoder) FlushFrame() (p *Packet, err error) {
+	if e.state == stateClosed {
+		err = ErrClosed
+		return
+	}
+	e.state = stateFlushing
+
+	for p == nil && encoderDelayedFrames(e.e) > 0 {
+		ret := encoderEncode(e.e, e.nals, &e.nnals, nil, e.picOut)
+		if ret < 0 {
+			err = &EncodeError{Frame: -1, Code: ret}
+			return
+		}
+		e.collect(ret)
+
+		p = e.packet(ret, e.picOut)
+	}
+
+	return
+}
+
+// Headers returns the SPS and PPS NAL units of the stream.
+func (e *Encoder) Headers() []Nal {
+	if e.headers == nil {
+		return nil
+	}
+	return e.headers.Nals
+}
+
+// packet copies size bytes of NAL units returned by the last x264 call, nil if there are none.
+func (e *Encoder) packet(size int32, pic *x264Picture) *Packet {
+	if size <= 0 {
+		return nil
+	}
+
+	p := &Packet{}
+	p.data = make([]byte, size)
+	copy(p.data, e.payload(size))
+
+	// NAL units of one call are contiguous in memory
+	nals := (*[1 << 16]x264Nal)(unsafe.Pointer(e.nals[0]))[:e.nnals:e.nnals]
+	p.Nals = make([]Nal, len(nals))
+
+	offset := int32(0)
+	for i := range nals {
+		p.Nals[i] = Nal{
+			Type:    NalType(nals[i].IType),
+			RefIdc:  NalPriority(nals[i].IRefIdc),
+			Payload: p.data[offset : offset+nals[i].IPayload],
+		}
+		offset += nals[i].IPayload
+	}
+
+	if pic != nil {
+		p.Pts = pic.IPts
+		p.Dts = pic.IDts
+		p.Type = FrameType(pic.IType)
+		p.Keyframe = pic.BKeyframe != 0
+	}
+
+	return p
+}
+
+// payload returns size bytes of NAL units returned by the last x264 call without copying.
+// The memory belongs to x264 and is valid until the next call.
+func (e *Encoder) payload(size int32) []byte {
+	if size <= 0 {
+		return nil
+	}
+	return planeBytes(e.nals[0].PPayload, int(size))
+}
+
+// write writes b to the writer.
+func (e *Encoder) write(b []byte) error {
+	if len(b) == 0 || e.w == nil {
+		return nil
+	}
+
+	n, err := e.w.Write(b)
+	return writeError(len(b), n, err)
+}
+
+// nextPts returns the pts of the next frame displayed as ps in timebase ticks and the field count after it.
+// With pulldown a frame lasts as many fields as its picture structure, x264 expects the pts after pulldown.
+func (e *Encoder) nextPts(ps PicStruct) (pts, fields int64) {
+	// computed from the field count, so fractional ticks per field don't accumulate an error
+	pts = e.basePts + (e.fields*e.tpfNum+e.tpfDen)/(2*e.tpfDen)
+	return pts, e.fields + ps.fields()
+}
+
+// timestamp returns the pts of a frame presented at d, which must come after the last frame.
+// x264 keeps the timebase only for VFR input or pulldown, otherwise it encodes at the frame rate.
+func (e *Encoder) timestamp(d time.Duration) (int64, error) {
+	if !e.opts.VFRInput && !e.opts.Pulldown {
+		return 0, ErrNotVFR
+	}
+
+	ticks, err := e.ticks(d)
+	if err != nil {
+		return 0, err
+	}
+
+	if ticks <= e.pts {
+		return 0, &TimestampError{Pts: ticks, Prev: e.pts}
+	}
+
+	return ticks, nil
+}
+
+// ticks converts time to timebase ticks, rounding to the nearest tick.
+func (e *Encoder) ticks(d time.Duration) (int64, error) {
+	if d < 0 {
+		return 0, fmt.Errorf("%w: negative timestamp %v", ErrTimestamp, d)
+	}
+
+	// d * den / (num * 1e9) without overflowing 64 bits
+	div := uint64(e.tbNum) * uint64(time.Second)
+	hi, lo := bits.Mul64(uint64(d), uint64(e.tbDen))
+	lo, carry := bits.Add64(lo, div/2, 0)
+	hi += carry
+	if hi >= div {
+		return 0, fmt.Errorf("%w: timestamp %v overflows the timebase", ErrTimestamp, d)
+	}
+
+	ticks, _ := bits.Div64(hi, lo, div)
+	if ticks > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: timestamp %v overflows the timebase", ErrTimestamp, d)
+	}
+
+	return int64(ticks), nil
+}
+
+// CloseContext flushes delayed frames to the writer and closes encoder.
+// When ctx is done before all frames are flushed, the encoder is closed anyway
+// and a *CancelError with the number of abandoned frames is returned.
+// Closing a closed encoder does nothing and returns nil.
+func (e *Encoder) CloseContext(ctx context.Context) error {
+	if e.state == stateClosed {
+		return nil
+	}
+
+	err := e.FlushContext(ctx)
+	e.close()
+	return err
+}
+
+// Close closes encoder, delayed frames are flushed to the writer first with Options.FlushOnClose.
+// Closing a closed encoder does nothing and returns nil, other methods return ErrClosed after Close.
+func (e *Encoder) Close() error {
+	if e.opts.FlushOnClose {
+		return e.CloseContext(context.Background())
+	}
+
+	e.close()
+	return nil
+}
+
+// close frees the encoder resources once.
+func (e *Encoder) close() {
+	if e.state == stateClosed {
+		return
+	}
+	e.state = stateClosed
+
+	e.in.Free()
+	encoderClose(e.e)
+	e.e = nil
+	zonesFree(e.zones)
+	e.zones = nil
+	if e.logID != 0 {
+		logFree(e.logID)
+		e.logID = 0
+	}
+
+	atomic.AddInt64(&openEncoders, -1)
+	runtime.SetFinalizer(e, nil)
+}
+
+// finalize closes an encoder that was garbage collected without Close, delayed frames are lost.
+func (e *Encoder) finalize() {
+	if e.state == stateClosed {
+		return
+	}
+
+	if e.opts.Logger != nil {
+		e.opts.Logger.Printf("x264: encoder was not closed, %d frames submitted", e.frames)
+	}
+	e.close()
+}
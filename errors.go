@@ -0,0 +1,122 @@
+package x264
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Errors returned by the encoders.
+var (
+	ErrInvalidPreset  = errors.New("x264: invalid preset/tune name")
+	ErrInvalidProfile = errors.New("x264: invalid profile name")
+	ErrAlloc          = errors.New("x264: cannot allocate memory")
+	ErrOpen           = errors.New("x264: cannot open the encoder")
+	ErrHeaders        = errors.New("x264: cannot encode headers")
+	ErrReconfigure    = errors.New("x264: cannot reconfigure the encoder")
+	ErrClosed         = errors.New("x264: encoder is closed")
+	ErrFlushing       = errors.New("x264: encoder is flushing")
+	ErrBitDepth       = errors.New("x264: bit depth is not supported by the library")
+	ErrInvalidOptions = errors.New("x264: invalid options")
+	ErrDelayedFrames  = errors.New("x264: delayed frames must be flushed first")
+	ErrFrameSize      = errors.New("x264: invalid frame size")
+	ErrEmptySource    = errors.New("x264: frame source is empty")
+	ErrNotVFR         = errors.New("x264: frame timestamps require VFR input or pulldown")
+	ErrTimestamp      = errors.New("x264: timestamp out of the timebase range")
+)
+
+// TimestampError is returned when a frame timestamp doesn't increase.
+type TimestampError struct {
+	// Rejected and previous frame pts in timebase ticks.
+	Pts, Prev int64
+}
+
+func (e *TimestampError) Error() string {
+	return fmt.Sprintf("x264: non-monotonic timestamp %d after %d", e.Pts, e.Prev)
+}
+
+// SizeError is returned when an image size differs from the encoder size and Options.ScaleMode is ScaleReject,
+// or when the image is empty.
+type SizeError struct {
+	// Image and encoder frame sizes.
+	Size, Want image.Point
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("x264: image size %dx%d differs from the frame size %dx%d", e.Size.X, e.Size.Y, e.Want.X, e.Want.Y)
+}
+
+// OptionError is returned when x264 rejects an option of Options.X264Params or Options.X264Options.
+type OptionError struct {
+	Name, Value string
+	// The option name is unknown, otherwise its value is invalid.
+	Unknown bool
+}
+
+func (e *OptionError) Error() string {
+	if e.Unknown {
+		return fmt.Sprintf("x264: unknown option %q", e.Name)
+	}
+	return fmt.Sprintf("x264: invalid value %q for option %q", e.Value, e.Name)
+}
+
+// EncodeError is returned when x264 fails to encode a frame.
+type EncodeError struct {
+	// Number of the submitted frame counted from zero, -1 while flushing delayed frames.
+	Frame int64
+	// Return code of x264_encoder_encode.
+	Code int32
+}
+
+func (e *EncodeError) Error() string {
+	if e.Frame < 0 {
+		return fmt.Sprintf("x264: cannot encode delayed frames, code %d", e.Code)
+	}
+	return fmt.Sprintf("x264: cannot encode frame %d, code %d", e.Frame, e.Code)
+}
+
+// WriteError is returned when writing encoded data fails.
+// A short write without a writer error wraps io.ErrShortWrite.
+type WriteError struct {
+	// Size of the data and the number of bytes written.
+	Size, N int
+	Err     error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("x264: error writing %d bytes, %d written: %v", e.Size, e.N, e.Err)
+}
+
+// Unwrap returns the writer error.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// writeError returns the error of writing size bytes, nil if they were written.
+func writeError(size, n int, err error) error {
+	if err == nil && n != size {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		return nil
+	}
+	return &WriteError{Size: size, N: n, Err: err}
+}
+
+// CancelError is returned when flushing or closing is cancelled by a context.
+type CancelError struct {
+	// Number of delayed frames that were not flushed.
+	Abandoned int
+	// The context error.
+	Err error
+}
+
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("x264: %d delayed frames abandoned: %v", e.Abandoned, e.Err)
+}
+
+// Unwrap returns the context error.
+func (e *CancelError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,307 @@
+package x264
+
+import (
+	"errors"
+	"image"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	col "github.com/sergystepanov/x264-go/v2/x264c/color"
+)
+
+// errWriter fails after n writes, short writes when err is nil.
+type errWriter struct {
+	n   int
+	err error
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	if w.n > 0 {
+		w.n--
+		return len(b), nil
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(b) - 1, nil
+}
+
+func testOptions() *Options {
+	return &Options{
+		Width:     320,
+		Height:    240,
+		FrameRate: 25,
+		Tune:      "zerolatency",
+		Preset:    "veryfast",
+		Profile:   "high",
+	}
+}
+
+func TestNewEncoderErrors(t *testing.T) {
+	errDisk := errors.New("disk full")
+
+	tests := []struct {
+		name string
+		w    io.Writer
+		opts func(*Options)
+		err  error
+	}{
+		{name: "preset", opts: func(o *Options) { o.Preset = "bogus" }, err: ErrInvalidPreset},
+		{name: "tune", opts: func(o *Options) { o.Tune = "bogus" }, err: ErrInvalidPreset},
+		{name: "profile", opts: func(o *Options) { o.Profile = "bogus" }, err: ErrInvalidProfile},
+		{name: "open", opts: func(o *Options) { o.X264Params = "crop-rect=400,0,0,0" }, err: ErrOpen},
+		{name: "headers write", w: &errWriter{err: errDisk}, err: errDisk},
+		{name: "headers short write", w: &errWriter{}, err: io.ErrShortWrite},
+	}
+
+	for _, test := range tests {
+		opts := testOptions()
+		if test.opts != nil {
+			test.opts(opts)
+		}
+
+		_, err := NewEncoder(test.w, opts)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for name, fn := range map[string]func(*Options){
+		"rate control": func(o *Options) { o.RateControl = RateControlABR },
+		"zones":        func(o *Options) { o.Zones = []Zone{{Start: 2, End: 1}} },
+		"timing":       func(o *Options) { o.TimebaseNum = 1 },
+		"colorspace":   func(o *Options) { o.Colorspace = -1 },
+		"color":        func(o *Options) { o.Color.Range = -1 },
+		"scale":        func(o *Options) { o.ScaleMode = -1 },
+		"interlace":    func(o *Options) { o.FieldOrder, o.FakeInterlaced = FieldTopFirst, true },
+		"crop":         func(o *Options) { o.Crop = image.Rect(0, 0, 400, 240) },
+		"aspect":       func(o *Options) { o.SampleAspectRatio = AspectRatio{1, 0} },
+	} {
+		opts := testOptions()
+		fn(opts)
+
+		if _, err := NewEncoder(nil, opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidOptions)
+		}
+	}
+
+	if _, err := NewEncoder(nil, &Options{}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("zero size: got %v, want %v", err, ErrInvalidOptions)
+	}
+	if _, err := NewFrame(0, 0); !errors.Is(err, ErrFrameSize) {
+		t.Errorf("zero size frame: got %v, want %v", err, ErrFrameSize)
+	}
+
+	if _, err := NewTwoPassEncoder(nil, &TwoPassOptions{}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("two-pass: got %v, want %v", err, ErrInvalidOptions)
+	}
+	tp, err := NewTwoPassEncoder(nil, &TwoPassOptions{Options: *testOptions(), TargetBitrate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tp.Encode(newTestSource(320, 240, 0)); !errors.Is(err, ErrEmptySource) {
+		t.Errorf("two-pass empty source: got %v, want %v", err, ErrEmptySource)
+	}
+
+	enc, err := NewEncoder(nil, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	if err = enc.SetBitrate(100); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("reconfigure: got %v, want %v", err, ErrInvalidOptions)
+	}
+
+	img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+	for name, opts := range map[string]*EncodeOptions{
+		"picture structure": {PicStruct: PicStructTopBottom},
+		"SEI":               {SEI: []SEIMessage{{Type: SEIUserDataUnregistered}}},
+	} {
+		if _, err = enc.EncodeFrameWith(img, opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidOptions)
+		}
+	}
+}
+
+func TestEncodeWriteError(t *testing.T) {
+	errDisk := errors.New("disk full")
+
+	for _, w := range []*errWriter{{n: 1, err: errDisk}, {n: 1}} {
+		enc, err := NewEncoder(w, testOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+		err = enc.Encode(img)
+		enc.Close()
+
+		var we *WriteError
+		if !errors.As(err, &we) {
+			t.Fatalf("got %v, want a WriteError", err)
+		}
+		if we.Size == 0 || we.N >= we.Size {
+			t.Errorf("got size %d, written %d", we.Size, we.N)
+		}
+		if w.err != nil && !errors.Is(err, errDisk) {
+			t.Errorf("got %v, want %v", err, errDisk)
+		}
+		if w.err == nil && !errors.Is(err, io.ErrShortWrite) {
+			t.Errorf("got %v, want %v", err, io.ErrShortWrite)
+		}
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	enc, err := NewEncoder(nil, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+	for i := 0; i < 3; i++ {
+		if _, err = enc.EncodeFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encode := encoderEncode
+	defer func() { encoderEncode = encode }()
+	encoderEncode = func(_ *x264T, _ []*x264Nal, _ *int32, _ *x264Picture, _ *x264Picture) int32 {
+		return -1
+	}
+
+	_, err = enc.EncodeFrame(img)
+
+	var ee *EncodeError
+	if !errors.As(err, &ee) {
+		t.Fatalf("got %v, want an EncodeError", err)
+	}
+	if ee.Frame != 3 || ee.Code != -1 {
+		t.Errorf("got frame %d, code %d", ee.Frame, ee.Code)
+	}
+}
+
+func TestTimestampErrors(t *testing.T) {
+	img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+
+	// CFR encoders round timestamps to the frame duration, x264 would reject them
+	enc, err := NewEncoder(nil, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = enc.EncodeFrameAt(img, 0); !errors.Is(err, ErrNotVFR) {
+		t.Errorf("CFR: got %v, want %v", err, ErrNotVFR)
+	}
+	if err = enc.EncodeWith(img, &EncodeOptions{Pts: 15 * time.Millisecond}); !errors.Is(err, ErrNotVFR) {
+		t.Errorf("CFR options: got %v, want %v", err, ErrNotVFR)
+	}
+	enc.Close()
+
+	opts := testOptions()
+	opts.VFRInput, opts.TimebaseNum, opts.TimebaseDen = true, 1, math.MaxInt32
+	enc, err = NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	for _, ts := range []time.Duration{0, 15 * time.Millisecond} {
+		if _, err = enc.EncodeFrameAt(img, ts); err != nil {
+			t.Fatalf("VFR %v: %v", ts, err)
+		}
+	}
+	for _, ts := range []time.Duration{-time.Millisecond, math.MaxInt64} {
+		if _, err = enc.EncodeFrameAt(img, ts); !errors.Is(err, ErrTimestamp) {
+			t.Errorf("%v: got %v, want %v", ts, err, ErrTimestamp)
+		}
+	}
+}
+
+func TestRejectedFrameTiming(t *testing.T) {
+	opts := testOptions()
+	opts.VFRInput, opts.TimebaseNum, opts.TimebaseDen = true, 1, 1000
+
+	enc, err := NewEncoder(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	img := col.NewYCbCr(image.Rect(0, 0, 320, 240))
+	if _, err = enc.EncodeFrameAt(img, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// frames rejected before and by x264 don't move the timestamps on
+	bad := []SEIMessage{{Type: SEIUserDataUnregistered}}
+	if _, err = enc.EncodeFrameWith(img, &EncodeOptions{Pts: 200 * time.Millisecond, SEI: bad}); err == nil {
+		t.Fatal("invalid SEI: expected error")
+	}
+
+	encode := encoderEncode
+	encoderEncode = func(_ *x264T, _ []*x264Nal, _ *int32, _ *x264Picture, _ *x264Picture) int32 {
+		return -1
+	}
+	_, err = enc.EncodeFrameAt(img, 300*time.Millisecond)
+	encoderEncode = encode
+	var ee *EncodeError
+	if !errors.As(err, &ee) || ee.Frame != 1 {
+		t.Fatalf("got %v, want an EncodeError of frame 1", err)
+	}
+
+	if _, err = enc.EncodeFrameAt(img, 150*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if enc.pts != 150 {
+		t.Errorf("got pts %d, want 150", enc.pts)
+	}
+
+	// the next untimed frame follows the last accepted one
+	if _, err = enc.EncodeFrame(img); err != nil {
+		t.Fatal(err)
+	}
+	if enc.pts != 190 || enc.frames != 3 {
+		t.Errorf("got pts %d after %d frames, want 190 after 3", enc.pts, enc.frames)
+	}
+}
+
+func TestReconfigureError(t *testing.T) {
+	enc, err := NewEncoder(nil, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	reconfig := encoderReconfig
+	defer func() { encoderReconfig = reconfig }()
+	encoderReconfig = func(_ *x264T, _ *x264Param) int32 {
+		return -1
+	}
+
+	if err = enc.SetCRF(30); !errors.Is(err, ErrReconfigure) {
+		t.Errorf("got %v, want %v", err, ErrReconfigure)
+	}
+}
+
+func TestNewEncoderStubErrors(t *testing.T) {
+	headers, alloc := encoderHeaders, pictureAlloc
+	defer func() { encoderHeaders, pictureAlloc = headers, alloc }()
+
+	encoderHeaders = func(_ *x264T, _ []*x264Nal, _ *int32) int32 { return -1 }
+	if _, err := NewEncoder(nil, testOptions()); !errors.Is(err, ErrHeaders) {
+		t.Errorf("got %v, want %v", err, ErrHeaders)
+	}
+	encoderHeaders = headers
+
+	pictureAlloc = func(_ *x264Picture, _ int32, _ int32, _ int32) int32 { return -1 }
+	if _, err := NewEncoder(nil, testOptions()); !errors.Is(err, ErrAlloc) {
+		t.Errorf("got %v, want %v", err, ErrAlloc)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -21,36 +22,62 @@ func main() {
 
 	bounds := screenshot.GetDisplayBounds(0)
 
-	opts := &x264.Options{
-		Width:     bounds.Dx(),
-		Height:    bounds.Dy(),
-		FrameRate: 60,
-		Tune:      "zerolatency",
-		Preset:    "ultrafast",
-		Profile:   "baseline",
-		//LogLevel:  x264.LogDebug,
+	opts := &x264.AsyncOptions{
+		Options: x264.Options{
+			Width:     bounds.Dx(),
+			Height:    bounds.Dy(),
+			FrameRate: 60,
+			VFRInput:  true,
+			Tune:      "zerolatency",
+			Preset:    "ultrafast",
+			Profile:   "baseline",
+			//LogLevel:  x264.LogDebug,
+		},
+		QueueSize: 4,
+		// a slow encoder drops old frames instead of slowing the capture down
+		Drop: x264.DropOldest,
 	}
 
-	enc, err := x264.NewEncoder(file, opts)
+	enc, err := x264.NewAsyncEncoder(opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
 	}
 
-	defer enc.Close()
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		for p := range enc.Packets() {
+			if err == nil {
+				_, err = file.Write(p.Bytes())
+			}
+		}
+		written <- err
+	}()
 
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
 
 	ticker := time.NewTicker(time.Second / time.Duration(60))
 
-	start := time.Now()
+	begin := time.Now()
+	start := begin
 	frame := 0
 
 	for range ticker.C {
 		select {
 		case <-s:
-			enc.Flush()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = enc.Close(ctx)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+
+			if err = <-written; err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+			log.Printf("dropped frames: %v", enc.Dropped())
 
 			err = file.Close()
 			if err != nil {
@@ -62,13 +89,14 @@ func main() {
 		default:
 			frame++
 			log.Printf("frame: %v", frame)
+			captured := time.Since(begin)
 			img, err := screenshot.CaptureRect(bounds)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 				continue
 			}
 
-			err = enc.Encode(img)
+			err = enc.EncodeAt(img, captured)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 			}
@@ -0,0 +1,642 @@
+package x264
+
+import (
+	"fmt"
+	"image"
+	imgcolor "image/color"
+	"image/draw"
+	"unsafe"
+
+	"github.com/sergystepanov/x264-go/v2/x264c/color"
+)
+
+// picture is a frame with planes in C memory that can be passed to x264 without copying.
+type picture interface {
+	draw.Image
+
+	// cpic returns the x264 picture, nil after Free.
+	cpic() *x264Picture
+	// fill converts the image into the frame.
+	fill(im image.Image)
+	// pad repeats the samples of the last column and row of the w x h image into the rest of the frame.
+	// Chroma samples shared with the image are kept.
+	pad(w, h int)
+
+	Free()
+}
+
+// Frame is a planar YCbCr image (I420, I422 or I444) with planes in C memory allocated by x264.
+// Frames of the encoder size and colorspace are passed to x264 as is, without conversion or copying,
+// and can be reused for the next image as soon as Encode returns. The planes of sizes that don't fit
+// the chroma subsampling cover the padded size, such frames are copied into a padded encoder frame.
+// Frames must be released with Free.
+type Frame struct {
+	*color.YCbCr
+
+	pic *x264Picture
+}
+
+// NVFrame is a semi-planar YCbCr 4:2:0 image (NV12 or NV21) with planes in C memory allocated by x264.
+// Frames must be released with Free.
+type NVFrame struct {
+	// Y plane and the interleaved chroma plane of half the height.
+	Y, UV []byte
+	// Strides of the planes in bytes.
+	YStride, UVStride int
+	Rect              image.Rectangle
+	// Conversion of the colors that are set, nil means color.YCbCrModel.
+	Model imgcolor.Model
+
+	// Cr comes first in the chroma plane
+	swap bool
+	pic  *x264Picture
+}
+
+// RGBFrame is a packed RGB image (BGR, BGRA or RGB) with pixels in C memory allocated by x264.
+// The alpha channel of BGRA is ignored by the encoder. Frames must be released with Free.
+type RGBFrame struct {
+	// Pixels in the colorspace order.
+	Pix []byte
+	// Stride of a row in bytes.
+	Stride int
+	Rect   image.Rectangle
+
+	// byte offsets of the red, green and blue components and the pixel size
+	r, g, b, size int
+	pic           *x264Picture
+}
+
+// Frame16 is a planar YCbCr image (I420, I422 or I444) with 16-bit samples in C memory allocated by x264,
+// the input of encoders with a bit depth above 8. Samples hold Depth significant bits, the upper bits must be zero.
+// Frames of the encoder depth are passed to x264 as is, frames of another depth are rescaled to it.
+// The color model exposes the 8 most significant bits. Frames must be released with Free.
+type Frame16 struct {
+	Y, Cb, Cr []uint16
+	// Strides of the planes in samples.
+	YStride, CStride int
+	SubsampleRatio   image.YCbCrSubsampleRatio
+	Rect             image.Rectangle
+	// Significant bits per sample.
+	Depth int
+	// Conversion of the colors that are set, nil means color.YCbCrModel.
+	Model imgcolor.Model
+
+	pic *x264Picture
+}
+
+// NewFrame allocates a new I420 frame.
+func NewFrame(width, height int) (*Frame, error) {
+	return NewYCbCrFrame(ColorspaceI420, width, height)
+}
+
+// NewYCbCrFrame allocates a new planar frame of the I420, I422 or I444 colorspace.
+func NewYCbCrFrame(cs Colorspace, width, height int) (*Frame, error) {
+	ratio, err := subsampleRatio(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	pic, err := allocPicture(cs, cs.csp(), width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	h, cHeight := planeHeights(cs, height)
+	ySize := int(pic.Img.IStride[0]) * h
+	cSize := int(pic.Img.IStride[1]) * cHeight
+
+	f := &Frame{pic: pic}
+	f.YCbCr = &color.YCbCr{YCbCr: &image.YCbCr{
+		Y:              planeBytes(pic.Img.Plane[0], ySize),
+		Cb:             planeBytes(pic.Img.Plane[1], cSize),
+		Cr:             planeBytes(pic.Img.Plane[2], cSize),
+		YStride:        int(pic.Img.IStride[0]),
+		CStride:        int(pic.Img.IStride[1]),
+		SubsampleRatio: ratio,
+		Rect:           image.Rect(0, 0, width, height),
+	}}
+
+	return f, nil
+}
+
+// NewFrame16 allocates a new planar frame of the I420, I422 or I444 colorspace with samples of depth bits.
+func NewFrame16(cs Colorspace, depth, width, height int) (*Frame16, error) {
+	ratio, err := subsampleRatio(cs)
+	if err != nil {
+		return nil, err
+	}
+	if depth <= 8 || depth > 16 {
+		return nil, fmt.Errorf("x264: invalid bit depth %d of a 16-bit frame", depth)
+	}
+
+	pic, err := allocPicture(cs, cs.csp()|cspHighDepth, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Frame16{
+		YStride:        int(pic.Img.IStride[0]) / 2,
+		CStride:        int(pic.Img.IStride[1]) / 2,
+		SubsampleRatio: ratio,
+		Rect:           image.Rect(0, 0, width, height),
+		Depth:          depth,
+		pic:            pic,
+	}
+
+	h, cHeight := planeHeights(cs, height)
+	f.Y = planeSamples(pic.Img.Plane[0], f.YStride*h)
+	f.Cb = planeSamples(pic.Img.Plane[1], f.CStride*cHeight)
+	f.Cr = planeSamples(pic.Img.Plane[2], f.CStride*cHeight)
+
+	return f, nil
+}
+
+// NewNVFrame allocates a new frame of the NV12 or NV21 colorspace.
+func NewNVFrame(cs Colorspace, width, height int) (*NVFrame, error) {
+	if cs != ColorspaceNV12 && cs != ColorspaceNV21 {
+		return nil, fmt.Errorf("x264: %v is not a semi-planar colorspace", cs)
+	}
+
+	pic, err := allocPicture(cs, cs.csp(), width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &NVFrame{
+		YStride:  int(pic.Img.IStride[0]),
+		UVStride: int(pic.Img.IStride[1]),
+		Rect:     image.Rect(0, 0, width, height),
+		swap:     cs == ColorspaceNV21,
+		pic:      pic,
+	}
+	h, cHeight := planeHeights(cs, height)
+	f.Y = planeBytes(pic.Img.Plane[0], f.YStride*h)
+	f.UV = planeBytes(pic.Img.Plane[1], f.UVStride*cHeight)
+
+	return f, nil
+}
+
+// NewRGBFrame allocates a new frame of the BGR, BGRA or RGB colorspace.
+func NewRGBFrame(cs Colorspace, width, height int) (*RGBFrame, error) {
+	f := &RGBFrame{Rect: image.Rect(0, 0, width, height)}
+	switch cs {
+	case ColorspaceBGR:
+		f.r, f.g, f.b, f.size = 2, 1, 0, 3
+	case ColorspaceBGRA:
+		f.r, f.g, f.b, f.size = 2, 1, 0, 4
+	case ColorspaceRGB:
+		f.r, f.g, f.b, f.size = 0, 1, 2, 3
+	default:
+		return nil, fmt.Errorf("x264: %v is not a packed RGB colorspace", cs)
+	}
+
+	pic, err := allocPicture(cs, cs.csp(), width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	f.pic = pic
+	f.Stride = int(pic.Img.IStride[0])
+	f.Pix = planeBytes(pic.Img.Plane[0], f.Stride*height)
+
+	return f, nil
+}
+
+// newPicture allocates a frame of the colorspace with samples of depth bits.
+func newPicture(cs Colorspace, depth, width, height int) (picture, error) {
+	if depth > 8 {
+		return NewFrame16(cs, depth, width, height)
+	}
+
+	switch cs {
+	case ColorspaceNV12, ColorspaceNV21:
+		return NewNVFrame(cs, width, height)
+	case ColorspaceBGR, ColorspaceBGRA, ColorspaceRGB:
+		return NewRGBFrame(cs, width, height)
+	}
+	return NewYCbCrFrame(cs, width, height)
+}
+
+// subsampleRatio returns the chroma subsampling of a planar YCbCr colorspace.
+func subsampleRatio(cs Colorspace) (image.YCbCrSubsampleRatio, error) {
+	switch cs {
+	case ColorspaceI420:
+		return image.YCbCrSubsampleRatio420, nil
+	case ColorspaceI422:
+		return image.YCbCrSubsampleRatio422, nil
+	case ColorspaceI444:
+		return image.YCbCrSubsampleRatio444, nil
+	}
+	return 0, fmt.Errorf("x264: %v is not a planar YCbCr colorspace", cs)
+}
+
+// pictureDepth returns the bits per sample of a frame.
+func pictureDepth(p picture) int {
+	if f, ok := p.(*Frame16); ok {
+		return f.Depth
+	}
+	return 8
+}
+
+// model returns m, color.YCbCrModel if it is nil.
+func model(m imgcolor.Model) imgcolor.Model {
+	if m == nil {
+		return imgcolor.YCbCrModel
+	}
+	return m
+}
+
+// ycbcrPlanes returns the planes of a YCbCr image with samples of the model m, nil for other images.
+// Go YCbCr images are JFIF.
+func ycbcrPlanes(im image.Image, m imgcolor.Model) *image.YCbCr {
+	src := imgcolor.YCbCrModel
+	switch c := im.(type) {
+	case *Frame:
+		im, src = c.YCbCr.YCbCr, c.ColorModel()
+	case *color.YCbCr:
+		im, src = c.YCbCr, c.ColorModel()
+	}
+
+	if s, ok := im.(*image.YCbCr); ok && src == m {
+		return s
+	}
+	return nil
+}
+
+// setModel sets the conversion of the colors set in YCbCr frames.
+func setModel(p picture, m imgcolor.Model) {
+	switch f := p.(type) {
+	case *Frame:
+		f.Model = m
+	case *Frame16:
+		f.Model = m
+	case *NVFrame:
+		f.Model = m
+	}
+}
+
+// allocPicture allocates an x264 picture of the x264 colorspace csp, its size is padded to the subsampling of cs.
+func allocPicture(cs Colorspace, csp int32, width, height int) (*x264Picture, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%w %dx%d", ErrFrameSize, width, height)
+	}
+
+	// x264 sizes the chroma planes of the size divided by the subsampling
+	sw, sh := cs.subsampling()
+	width, height = alignUp(width, sw), alignUp(height, sh)
+
+	// kept apart from Go pointers, so it can be passed to C
+	pic := &x264Picture{}
+	if pictureAlloc(pic, csp, int32(width), int32(height)) < 0 {
+		return nil, ErrAlloc
+	}
+
+	return pic, nil
+}
+
+// planeHeights returns the number of luma and chroma rows of a picture of the colorspace padded to its subsampling.
+func planeHeights(cs Colorspace, height int) (h, cHeight int) {
+	_, sh := cs.subsampling()
+	h = alignUp(height, sh)
+	return h, h / sh
+}
+
+func (f *Frame) cpic() *x264Picture { return f.pic }
+
+func (f *Frame) fill(im image.Image) { f.ToYCbCr(im) }
+
+func (f *Frame) pad(w, h int) {
+	pw, ph := f.Rect.Dx(), f.Rect.Dy()
+	padBytes(f.Y, f.YStride, 1, w, h, pw, ph)
+
+	sw, sh := subsampleFactors(f.SubsampleRatio)
+	padBytes(f.Cb, f.CStride, 1, divUp(w, sw), divUp(h, sh), pw/sw, ph/sh)
+	padBytes(f.Cr, f.CStride, 1, divUp(w, sw), divUp(h, sh), pw/sw, ph/sh)
+}
+
+// Free releases the frame memory, the frame must not be used after that.
+func (f *Frame) Free() {
+	if f.pic == nil {
+		return
+	}
+
+	f.Y, f.Cb, f.Cr = nil, nil, nil
+	pictureClean(f.pic)
+	f.pic = nil
+}
+
+// ColorModel returns the conversion of the colors that are set.
+func (f *Frame16) ColorModel() imgcolor.Model { return model(f.Model) }
+
+// Bounds returns the frame bounds.
+func (f *Frame16) Bounds() image.Rectangle { return f.Rect }
+
+// At returns the color of the pixel at (x, y) reduced to 8 bits per sample.
+func (f *Frame16) At(x, y int) imgcolor.Color {
+	if !image.Pt(x, y).In(f.Rect) {
+		return imgcolor.YCbCr{}
+	}
+
+	shift := uint(f.Depth - 8)
+	yi, ci := f.YOffset(x, y), f.COffset(x, y)
+	return imgcolor.YCbCr{Y: uint8(f.Y[yi] >> shift), Cb: uint8(f.Cb[ci] >> shift), Cr: uint8(f.Cr[ci] >> shift)}
+}
+
+// Set sets the color of the pixel at (x, y), 8-bit samples are scaled to the frame depth.
+func (f *Frame16) Set(x, y int, c imgcolor.Color) {
+	if !image.Pt(x, y).In(f.Rect) {
+		return
+	}
+
+	ycc := f.ColorModel().Convert(c).(imgcolor.YCbCr)
+	shift := uint(f.Depth - 8)
+	yi, ci := f.YOffset(x, y), f.COffset(x, y)
+	f.Y[yi] = uint16(ycc.Y) << shift
+	f.Cb[ci] = uint16(ycc.Cb) << shift
+	f.Cr[ci] = uint16(ycc.Cr) << shift
+}
+
+// YOffset returns the index of the Y sample of the pixel at (x, y).
+func (f *Frame16) YOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.YStride + (x - f.Rect.Min.X)
+}
+
+// COffset returns the index of the Cb and Cr samples of the pixel at (x, y).
+func (f *Frame16) COffset(x, y int) int {
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	switch f.SubsampleRatio {
+	case image.YCbCrSubsampleRatio422:
+		return y*f.CStride + x/2
+	case image.YCbCrSubsampleRatio420:
+		return (y/2)*f.CStride + x/2
+	}
+	return y*f.CStride + x
+}
+
+func (f *Frame16) cpic() *x264Picture { return f.pic }
+
+// fill converts the image into the frame, YCbCr images and 16-bit frames of the frame size, subsampling
+// and model are scaled plane by plane.
+func (f *Frame16) fill(im image.Image) {
+	if s, ok := im.(*Frame16); ok && s.SubsampleRatio == f.SubsampleRatio && s.Rect.Eq(f.Rect) &&
+		s.ColorModel() == f.ColorModel() {
+		f.rescale(s)
+		return
+	}
+
+	s := ycbcrPlanes(im, f.ColorModel())
+	if s == nil || s.SubsampleRatio != f.SubsampleRatio || !s.Rect.Eq(f.Rect) {
+		draw.Draw(f, f.Rect, im, im.Bounds().Min, draw.Src)
+		return
+	}
+
+	shift := uint(f.Depth - 8)
+	r := f.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		dst, src := f.Y[f.YOffset(r.Min.X, y):], s.Y[s.YOffset(r.Min.X, y):]
+		for x := 0; x < r.Dx(); x++ {
+			dst[x] = uint16(src[x]) << shift
+		}
+
+		// the first luma row of a chroma row converts it
+		if ci := f.COffset(r.Min.X, y); y == r.Min.Y || ci != f.COffset(r.Min.X, y-1) {
+			cw := f.COffset(r.Max.X-1, y) - ci + 1
+			sci := s.COffset(r.Min.X, y)
+			for x := 0; x < cw; x++ {
+				f.Cb[ci+x] = uint16(s.Cb[sci+x]) << shift
+				f.Cr[ci+x] = uint16(s.Cr[sci+x]) << shift
+			}
+		}
+	}
+}
+
+func (f *Frame16) pad(w, h int) {
+	pw, ph := f.Rect.Dx(), f.Rect.Dy()
+	padSamples(f.Y, f.YStride, w, h, pw, ph)
+
+	sw, sh := subsampleFactors(f.SubsampleRatio)
+	padSamples(f.Cb, f.CStride, divUp(w, sw), divUp(h, sh), pw/sw, ph/sh)
+	padSamples(f.Cr, f.CStride, divUp(w, sw), divUp(h, sh), pw/sw, ph/sh)
+}
+
+// rescale copies the samples of a frame with the same layout to the frame depth.
+func (f *Frame16) rescale(s *Frame16) {
+	r := f.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		yi := f.YOffset(r.Min.X, y)
+		rescaleSamples(f.Y[yi:yi+r.Dx()], s.Y[s.YOffset(r.Min.X, y):], s.Depth, f.Depth)
+
+		// the first luma row of a chroma row converts it
+		if ci := f.COffset(r.Min.X, y); y == r.Min.Y || ci != f.COffset(r.Min.X, y-1) {
+			cw := f.COffset(r.Max.X-1, y) - ci + 1
+			sci := s.COffset(r.Min.X, y)
+			rescaleSamples(f.Cb[ci:ci+cw], s.Cb[sci:], s.Depth, f.Depth)
+			rescaleSamples(f.Cr[ci:ci+cw], s.Cr[sci:], s.Depth, f.Depth)
+		}
+	}
+}
+
+// rescaleSamples converts samples of from bits to samples of to bits, rounding to the nearest value.
+func rescaleSamples(dst, src []uint16, from, to int) {
+	if to >= from {
+		shift := uint(to - from)
+		for i := range dst {
+			dst[i] = src[i] << shift
+		}
+		return
+	}
+
+	shift, max := uint(from-to), uint32(1)<<uint(to)-1
+	for i := range dst {
+		v := (uint32(src[i]) + 1<<(shift-1)) >> shift
+		if v > max {
+			v = max
+		}
+		dst[i] = uint16(v)
+	}
+}
+
+// Free releases the frame memory, the frame must not be used after that.
+func (f *Frame16) Free() {
+	if f.pic == nil {
+		return
+	}
+
+	f.Y, f.Cb, f.Cr = nil, nil, nil
+	pictureClean(f.pic)
+	f.pic = nil
+}
+
+// ColorModel returns the conversion of the colors that are set.
+func (f *NVFrame) ColorModel() imgcolor.Model { return model(f.Model) }
+
+// Bounds returns the frame bounds.
+func (f *NVFrame) Bounds() image.Rectangle { return f.Rect }
+
+// At returns the color of the pixel at (x, y).
+func (f *NVFrame) At(x, y int) imgcolor.Color {
+	if !image.Pt(x, y).In(f.Rect) {
+		return imgcolor.YCbCr{}
+	}
+
+	yi, ci := f.offsets(x, y)
+	cb, cr := f.UV[ci], f.UV[ci+1]
+	if f.swap {
+		cb, cr = cr, cb
+	}
+
+	return imgcolor.YCbCr{Y: f.Y[yi], Cb: cb, Cr: cr}
+}
+
+// Set sets the color of the pixel at (x, y), the chroma is shared by 2x2 pixels.
+func (f *NVFrame) Set(x, y int, c imgcolor.Color) {
+	if !image.Pt(x, y).In(f.Rect) {
+		return
+	}
+
+	ycc := f.ColorModel().Convert(c).(imgcolor.YCbCr)
+	yi, ci := f.offsets(x, y)
+	f.Y[yi] = ycc.Y
+	if f.swap {
+		f.UV[ci], f.UV[ci+1] = ycc.Cr, ycc.Cb
+	} else {
+		f.UV[ci], f.UV[ci+1] = ycc.Cb, ycc.Cr
+	}
+}
+
+// offsets returns the offsets of the luma and the first chroma sample of the pixel at (x, y).
+func (f *NVFrame) offsets(x, y int) (int, int) {
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	return y*f.YStride + x, (y/2)*f.UVStride + (x/2)*2
+}
+
+func (f *NVFrame) cpic() *x264Picture { return f.pic }
+
+// fill converts the image into the frame, 4:2:0 images of the frame size and model are copied plane by plane.
+func (f *NVFrame) fill(im image.Image) {
+	s := ycbcrPlanes(im, f.ColorModel())
+	if s == nil || s.SubsampleRatio != image.YCbCrSubsampleRatio420 || !s.Rect.Eq(f.Rect) {
+		draw.Draw(f, f.Rect, im, im.Bounds().Min, draw.Src)
+		return
+	}
+
+	w, h := f.Rect.Dx(), f.Rect.Dy()
+	for y := 0; y < h; y++ {
+		copy(f.Y[y*f.YStride:y*f.YStride+w], s.Y[s.YOffset(s.Rect.Min.X, s.Rect.Min.Y+y):])
+	}
+
+	cb, cr := s.Cb, s.Cr
+	if f.swap {
+		cb, cr = cr, cb
+	}
+	// odd sizes have a chroma sample for the last column and row
+	for y := 0; y < (h+1)/2; y++ {
+		row := f.UV[y*f.UVStride:]
+		ci := s.COffset(s.Rect.Min.X, s.Rect.Min.Y+2*y)
+		for x := 0; x < (w+1)/2; x++ {
+			row[2*x], row[2*x+1] = cb[ci+x], cr[ci+x]
+		}
+	}
+}
+
+func (f *NVFrame) pad(w, h int) {
+	pw, ph := f.Rect.Dx(), f.Rect.Dy()
+	padBytes(f.Y, f.YStride, 1, w, h, pw, ph)
+	// Cb and Cr pairs
+	padBytes(f.UV, f.UVStride, 2, divUp(w, 2), divUp(h, 2), pw/2, ph/2)
+}
+
+// Free releases the frame memory, the frame must not be used after that.
+func (f *NVFrame) Free() {
+	if f.pic == nil {
+		return
+	}
+
+	f.Y, f.UV = nil, nil
+	pictureClean(f.pic)
+	f.pic = nil
+}
+
+// ColorModel returns the RGBA color model.
+func (f *RGBFrame) ColorModel() imgcolor.Model { return imgcolor.RGBAModel }
+
+// Bounds returns the frame bounds.
+func (f *RGBFrame) Bounds() image.Rectangle { return f.Rect }
+
+// At returns the color of the pixel at (x, y), always opaque.
+func (f *RGBFrame) At(x, y int) imgcolor.Color {
+	if !image.Pt(x, y).In(f.Rect) {
+		return imgcolor.RGBA{}
+	}
+
+	p := f.Pix[f.PixOffset(x, y):]
+	return imgcolor.RGBA{R: p[f.r], G: p[f.g], B: p[f.b], A: 0xff}
+}
+
+// Set sets the color of the pixel at (x, y).
+func (f *RGBFrame) Set(x, y int, c imgcolor.Color) {
+	if !image.Pt(x, y).In(f.Rect) {
+		return
+	}
+
+	rgba := imgcolor.RGBAModel.Convert(c).(imgcolor.RGBA)
+	p := f.Pix[f.PixOffset(x, y):]
+	p[f.r], p[f.g], p[f.b] = rgba.R, rgba.G, rgba.B
+	if f.size == 4 {
+		p[3] = 0xff
+	}
+}
+
+// PixOffset returns the index of the first byte of the pixel at (x, y) in Pix.
+func (f *RGBFrame) PixOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*f.size
+}
+
+func (f *RGBFrame) cpic() *x264Picture { return f.pic }
+
+// fill converts the image into the frame, RGBA images of the frame size are copied pixel by pixel.
+func (f *RGBFrame) fill(im image.Image) {
+	s, ok := im.(*image.RGBA)
+	if !ok || !s.Rect.Eq(f.Rect) {
+		draw.Draw(f, f.Rect, im, im.Bounds().Min, draw.Src)
+		return
+	}
+
+	w, h := f.Rect.Dx(), f.Rect.Dy()
+	for y := 0; y < h; y++ {
+		src := s.Pix[y*s.Stride : y*s.Stride+w*4]
+		dst := f.Pix[y*f.Stride:]
+		for x := 0; x < w; x++ {
+			d := dst[x*f.size:]
+			d[f.r], d[f.g], d[f.b] = src[4*x], src[4*x+1], src[4*x+2]
+			if f.size == 4 {
+				d[3] = 0xff
+			}
+		}
+	}
+}
+
+func (f *RGBFrame) pad(w, h int) {
+	padBytes(f.Pix, f.Stride, f.size, w, h, f.Rect.Dx(), f.Rect.Dy())
+}
+
+// Free releases the frame memory, the frame must not be used after that.
+func (f *RGBFrame) Free() {
+	if f.pic == nil {
+		return
+	}
+
+	f.Pix = nil
+	pictureClean(f.pic)
+	f.pic = nil
+}
+
+// planeBytes returns C memory as a byte slice.
+func planeBytes(p unsafe.Pointer, size int) []byte {
+	return (*[1 << 30]byte)(p)[:size:size]
+}
+
+// planeSamples returns C memory as a slice of 16-bit samples.
+func planeSamples(p unsafe.Pointer, size int) []uint16 {
+	return (*[1 << 29]uint16)(p)[:size:size]
+}
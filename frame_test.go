@@ -0,0 +1,265 @@
+package x264
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFrameColors(t *testing.T) {
+	for _, cs := range []Colorspace{ColorspaceI420, ColorspaceI422, ColorspaceI444,
+		ColorspaceNV12, ColorspaceNV21, ColorspaceBGR, ColorspaceBGRA, ColorspaceRGB} {
+		f, err := newPicture(cs, 8, 16, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := f.cpic().Img.ICsp; got != cs.csp() {
+			t.Errorf("%v: got csp %#x, want %#x", cs, got, cs.csp())
+		}
+		if b := f.Bounds(); b != image.Rect(0, 0, 16, 8) {
+			t.Errorf("%v: got bounds %v", cs, b)
+		}
+
+		// a gray pixel survives every colorspace and chroma subsampling
+		c := color.RGBA{128, 128, 128, 255}
+		f.Set(3, 5, c)
+		r, g, b, _ := f.At(3, 5).RGBA()
+		if r>>8 != 128 || g>>8 != 128 || b>>8 != 128 {
+			t.Errorf("%v: got %d %d %d, want 128", cs, r>>8, g>>8, b>>8)
+		}
+
+		f.Free()
+		f.Free()
+		if f.cpic() != nil {
+			t.Errorf("%v: picture not released", cs)
+		}
+	}
+}
+
+func TestRGBFrameLayout(t *testing.T) {
+	tests := []struct {
+		cs   Colorspace
+		want []byte
+	}{
+		{ColorspaceBGR, []byte{3, 2, 1}},
+		{ColorspaceBGRA, []byte{3, 2, 1, 255}},
+		{ColorspaceRGB, []byte{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		f, err := NewRGBFrame(test.cs, 4, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.Set(1, 1, color.RGBA{1, 2, 3, 255})
+		i := f.PixOffset(1, 1)
+		if got := f.Pix[i : i+len(test.want)]; string(got) != string(test.want) {
+			t.Errorf("%v: got %v, want %v", test.cs, got, test.want)
+		}
+		f.Free()
+	}
+
+	if _, err := NewRGBFrame(ColorspaceNV12, 4, 2); err == nil {
+		t.Error("NV12 RGB frame: expected error")
+	}
+}
+
+func TestNVFrameLayout(t *testing.T) {
+	for _, cs := range []Colorspace{ColorspaceNV12, ColorspaceNV21} {
+		f, err := NewNVFrame(cs, 4, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.Set(3, 3, color.YCbCr{Y: 10, Cb: 20, Cr: 30})
+		if f.Y[3*f.YStride+3] != 10 {
+			t.Errorf("%v: got Y %d, want 10", cs, f.Y[3*f.YStride+3])
+		}
+		uv := f.UV[f.UVStride+2 : f.UVStride+4]
+		want := []byte{20, 30}
+		if cs == ColorspaceNV21 {
+			want = []byte{30, 20}
+		}
+		if string(uv) != string(want) {
+			t.Errorf("%v: got chroma %v, want %v", cs, uv, want)
+		}
+		f.Free()
+	}
+}
+
+func TestFrame16(t *testing.T) {
+	for _, cs := range []Colorspace{ColorspaceI420, ColorspaceI422, ColorspaceI444} {
+		f, err := NewFrame16(cs, 10, 16, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := f.cpic().Img.ICsp, cs.csp()|cspHighDepth; got != want {
+			t.Errorf("%v: got csp %#x, want %#x", cs, got, want)
+		}
+
+		f.Set(5, 3, color.YCbCr{Y: 100, Cb: 50, Cr: 200})
+		if got := f.At(5, 3); got != (color.YCbCr{Y: 100, Cb: 50, Cr: 200}) {
+			t.Errorf("%v: got %v", cs, got)
+		}
+		if y, cb := f.Y[f.YOffset(5, 3)], f.Cb[f.COffset(5, 3)]; y != 400 || cb != 200 {
+			t.Errorf("%v: got samples %d %d, want 400 200", cs, y, cb)
+		}
+
+		ratio, _ := subsampleRatio(cs)
+		src := image.NewYCbCr(f.Rect, ratio)
+		for i := range src.Y {
+			src.Y[i] = uint8(i)
+		}
+		for i := range src.Cb {
+			src.Cb[i], src.Cr[i] = uint8(i), uint8(255-i)
+		}
+		f.fill(src)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 16; x++ {
+				if got, want := f.At(x, y), src.At(x, y); got != want {
+					t.Fatalf("%v: pixel %d,%d: got %v, want %v", cs, x, y, got, want)
+				}
+			}
+		}
+
+		f.Free()
+	}
+
+	// frames of another depth are rescaled, white, gray and a third of the range
+	for _, test := range []struct {
+		depth     int
+		y, cb, cr uint16
+	}{
+		{9, 1022, 512, 340},
+		{12, 1023, 512, 341},
+		{16, 1023, 512, 341},
+	} {
+		src, err := NewFrame16(ColorspaceI420, test.depth, 5, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := NewFrame16(ColorspaceI420, 10, 5, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		max := uint16(1<<uint(test.depth) - 1)
+		for i := range src.Y {
+			src.Y[i] = max
+		}
+		for i := range src.Cb {
+			src.Cb[i], src.Cr[i] = max/2+1, max/3
+		}
+		dst.fill(src)
+
+		y, cb, cr := dst.Y[dst.YOffset(4, 4)], dst.Cb[dst.COffset(4, 4)], dst.Cr[dst.COffset(4, 4)]
+		if y != test.y || cb != test.cb || cr != test.cr {
+			t.Errorf("%d-bit: got %d %d %d, want %d %d %d", test.depth, y, cb, cr, test.y, test.cb, test.cr)
+		}
+
+		src.Free()
+		dst.Free()
+	}
+
+	if _, err := NewFrame16(ColorspaceNV12, 10, 16, 8); err == nil {
+		t.Error("NV12 16-bit frame: expected error")
+	}
+	if _, err := NewFrame16(ColorspaceI420, 8, 16, 8); err == nil {
+		t.Error("8-bit 16-bit frame: expected error")
+	}
+}
+
+func TestFrameOddSize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 10)
+	}
+
+	for _, cs := range []Colorspace{ColorspaceI420, ColorspaceI422, ColorspaceI444, ColorspaceNV12, ColorspaceNV21} {
+		for _, depth := range []int{8, 10} {
+			if depth > 8 && !cs.planar() {
+				continue
+			}
+
+			f, err := newPicture(cs, depth, 5, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := f.Bounds(); b != image.Rect(0, 0, 5, 5) {
+				t.Errorf("%v %d-bit: got bounds %v", cs, depth, b)
+			}
+
+			// the last column and row have their own chroma samples
+			draw.Draw(f, f.Bounds(), src, image.Point{}, draw.Src)
+			c := color.YCbCrModel.Convert(src.At(4, 4)).(color.YCbCr)
+			if got := f.At(4, 4).(color.YCbCr); got.Y != c.Y {
+				t.Errorf("%v %d-bit: got %v at 4,4, want luma %d", cs, depth, got, c.Y)
+			}
+
+			// a copy of the frame reads its planes
+			dst, err := newPicture(cs, depth, 6, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst.fill(f)
+			if got, want := dst.At(4, 4), f.At(4, 4); got != want {
+				t.Errorf("%v %d-bit: got %v after fill, want %v", cs, depth, got, want)
+			}
+
+			dst.Free()
+			f.Free()
+		}
+	}
+}
+
+func TestFramePad(t *testing.T) {
+	// a 5x5 image in a 6x6 frame, 10-bit samples keep their low bits
+	f, err := NewFrame16(ColorspaceI420, 10, 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Free()
+
+	for i := range f.Y {
+		f.Y[i] = uint16(i) + 0x201
+	}
+	for i := range f.Cb {
+		f.Cb[i], f.Cr[i] = uint16(i)+0x101, 0x3ff-uint16(i)
+	}
+	cb, cr := append([]uint16(nil), f.Cb...), append([]uint16(nil), f.Cr...)
+
+	f.pad(5, 5)
+	for y := 0; y < 6; y++ {
+		if got, want := f.Y[f.YOffset(5, y)], f.Y[f.YOffset(4, y)]; got != want {
+			t.Errorf("column padding at row %d: got %#x, want %#x", y, got, want)
+		}
+	}
+	for x := 0; x < 6; x++ {
+		if got, want := f.Y[f.YOffset(x, 5)], f.Y[f.YOffset(x, 4)]; got != want {
+			t.Errorf("row padding at column %d: got %#x, want %#x", x, got, want)
+		}
+	}
+	// the chroma of the last column and row is shared with the padding
+	for i := range cb {
+		if f.Cb[i] != cb[i] || f.Cr[i] != cr[i] {
+			t.Errorf("chroma %d: got %#x %#x, want %#x %#x", i, f.Cb[i], f.Cr[i], cb[i], cr[i])
+		}
+	}
+
+	// NV12 pads Cb and Cr pairs
+	nv, err := NewNVFrame(ColorspaceNV12, 4, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nv.Free()
+
+	nv.Set(1, 1, color.YCbCr{Y: 10, Cb: 20, Cr: 30})
+	nv.pad(2, 2)
+	if got := nv.At(3, 5); got != (color.YCbCr{Y: 10, Cb: 20, Cr: 30}) {
+		t.Errorf("NV12: got %v in the padding", got)
+	}
+}
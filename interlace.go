@@ -0,0 +1,167 @@
+package x264
+
+import "fmt"
+
+// FieldOrder is the scan of the encoded frames.
+type FieldOrder int
+
+// Field orders.
+const (
+	// Progressive frames, the default.
+	FieldProgressive FieldOrder = iota
+	// Interlaced frames with the top field first, coded as MBAFF.
+	FieldTopFirst
+	// Interlaced frames with the bottom field first, coded as MBAFF.
+	FieldBottomFirst
+)
+
+// PicStruct is how a frame is displayed, it is signaled in the pic timing SEI.
+type PicStruct int
+
+// Picture structures, see H.264 Table D-1.
+const (
+	// Progressive, or both fields in the FieldOrder for interlaced streams.
+	PicStructAuto PicStruct = iota
+	// Progressive frame.
+	PicStructProgressive
+	// Top field followed by the bottom field.
+	PicStructTopBottom
+	// Bottom field followed by the top field.
+	PicStructBottomTop
+	// Top field, bottom field, top field repeated, the long frames of 3:2 pulldown.
+	PicStructTopBottomTop
+	// Bottom field, top field, bottom field repeated, the long frames of 3:2 pulldown.
+	PicStructBottomTopBottom
+	// Frame displayed twice.
+	PicStructDouble
+	// Frame displayed three times.
+	PicStructTriple
+)
+
+var picStructNames = [...]string{
+	PicStructAuto:            "Auto",
+	PicStructProgressive:     "Progressive",
+	PicStructTopBottom:       "TopBottom",
+	PicStructBottomTop:       "BottomTop",
+	PicStructTopBottomTop:    "TopBottomTop",
+	PicStructBottomTopBottom: "BottomTopBottom",
+	PicStructDouble:          "Double",
+	PicStructTriple:          "Triple",
+}
+
+func (p PicStruct) String() string {
+	if p.valid() {
+		return picStructNames[p]
+	}
+	return fmt.Sprintf("PicStruct(%d)", int(p))
+}
+
+// valid reports whether p is a known picture structure.
+func (p PicStruct) valid() bool {
+	return p >= 0 && int(p) < len(picStructNames)
+}
+
+// picStruct returns the x264 pic_struct constant.
+func (p PicStruct) picStruct() int32 {
+	return [...]int32{
+		PicStructAuto:            picStructAuto,
+		PicStructProgressive:     picStructProgressive,
+		PicStructTopBottom:       picStructTopBottom,
+		PicStructBottomTop:       picStructBottomTop,
+		PicStructTopBottomTop:    picStructTopBottomTop,
+		PicStructBottomTopBottom: picStructBottomTopBottom,
+		PicStructDouble:          picStructDouble,
+		PicStructTriple:          picStructTriple,
+	}[p]
+}
+
+// fields returns the number of field periods the frame is displayed for.
+func (p PicStruct) fields() int64 {
+	switch p {
+	case PicStructTopBottomTop, PicStructBottomTopBottom:
+		return 3
+	case PicStructDouble:
+		return 4
+	case PicStructTriple:
+		return 6
+	}
+	return 2
+}
+
+// repeats reports whether the frame is displayed for longer than one frame period.
+func (p PicStruct) repeats() bool {
+	return p.fields() > 2
+}
+
+// interlaced reports whether the stream is flagged as interlaced.
+func (o *Options) interlaced() bool {
+	return o.FieldOrder != FieldProgressive || o.FakeInterlaced
+}
+
+// validateInterlace checks the field order and pulldown options.
+func (o *Options) validateInterlace() error {
+	if o.FieldOrder < FieldProgressive || o.FieldOrder > FieldBottomFirst {
+		return fmt.Errorf("%w: unknown field order %d", ErrInvalidOptions, o.FieldOrder)
+	}
+	if o.FakeInterlaced && o.FieldOrder != FieldProgressive {
+		return fmt.Errorf("%w: fake interlacing codes progressive frames, the field order must be progressive",
+			ErrInvalidOptions)
+	}
+	if !o.Pulldown {
+		return nil
+	}
+
+	// the timebase must be the constant output frame duration
+	if o.VFRInput {
+		return fmt.Errorf("%w: pulldown requires constant frame rate input", ErrInvalidOptions)
+	}
+	if num, den := o.frameRate(); num > 0 && o.TimebaseNum > 0 && int64(o.TimebaseNum)*num != int64(o.TimebaseDen)*den {
+		return fmt.Errorf("%w: pulldown timebase %d/%d differs from the frame duration %d/%d", ErrInvalidOptions,
+			o.TimebaseNum, o.TimebaseDen, den, num)
+	}
+
+	return nil
+}
+
+// applyInterlace sets the interlacing and pulldown parameters.
+func applyInterlace(param *x264Param, opts *Options) {
+	switch opts.FieldOrder {
+	case FieldTopFirst:
+		param.BInterlaced = 1
+		param.BTff = 1
+	case FieldBottomFirst:
+		param.BInterlaced = 1
+		param.BTff = 0
+	}
+	if opts.FakeInterlaced {
+		param.BFakeInterlaced = 1
+	}
+
+	// x264 signals the picture structure of interlaced frames on its own
+	if opts.FakeInterlaced || opts.Pulldown {
+		param.BPicStruct = 1
+	}
+	if opts.Pulldown {
+		// keeps the timebase, which x264 otherwise replaces with the frame rate
+		param.BPulldown = 1
+	}
+}
+
+// validatePicStruct checks the picture structure of a frame against the stream options.
+func (o *Options) validatePicStruct(p PicStruct) error {
+	if !p.valid() {
+		return fmt.Errorf("%w: unknown picture structure %d", ErrInvalidOptions, int(p))
+	}
+	if p == PicStructAuto {
+		return nil
+	}
+
+	if p.repeats() && !o.Pulldown {
+		return fmt.Errorf("%w: picture structure %v requires Options.Pulldown", ErrInvalidOptions, p)
+	}
+	if !o.interlaced() && !o.Pulldown {
+		return fmt.Errorf("%w: picture structure %v requires an interlaced stream or Options.Pulldown", ErrInvalidOptions, p)
+	}
+
+	return nil
+}
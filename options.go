@@ -0,0 +1,314 @@
+package x264
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Logging constants.
+const (
+	LogNone int32 = iota - 1
+	LogError
+	LogWarning
+	LogInfo
+	LogDebug
+)
+
+// RateControl is a rate-control mode.
+type RateControl int
+
+// Rate-control modes.
+const (
+	// Constant rate factor (quality-based VBR), the default.
+	RateControlCRF RateControl = iota
+	// Constant quantizer.
+	RateControlCQP
+	// Average bitrate.
+	RateControlABR
+	// Constant bitrate, ABR with the VBV max rate pinned to the target bitrate.
+	RateControlCBR
+)
+
+const (
+	// defaultCRF is used when Options.CRF is not set.
+	defaultCRF = 28
+	// vfrTimebaseDen is the default timebase denominator for VFR input.
+	vfrTimebaseDen = 90000
+	// maxQP is the highest quantizer (and rate factor) for 8-bit encoding.
+	maxQP = 51
+)
+
+// Options represent encoding options.
+type Options struct {
+	// Frame width.
+	Width int
+	// Frame height.
+	Height int
+	// Displayed part of the frame signaled by SPS cropping, zero means the whole frame.
+	// Its edges are aligned outward to the chroma subsampling, multiples of 2 for 4:2:0.
+	// Sizes that are not multiples of the subsampling are padded by repeating the last column and row,
+	// which are then displayed as well, only 4:4:4 can represent odd sizes exactly.
+	Crop image.Rectangle
+	// Input colorspace, I420 by default. Frames of the colorspace are passed to x264 as is,
+	// other images are converted to it. A lower Profile is raised to the one the colorspace requires.
+	Colorspace Colorspace
+	// Color description signaled in the SPS, which is also the conversion of images into frames.
+	// The zero value is BT.601 in the full range, the colors of Go YCbCr images.
+	Color Color
+	// Shape of the pixels signaled in the SPS, e.g. 32:27 for 16:9 NTSC DVD, zero means unspecified.
+	SampleAspectRatio AspectRatio
+	// Shape of the displayed picture, signaled as the sample aspect ratio of the displayed size, e.g. 16:9.
+	// Only one of SampleAspectRatio and DisplayAspectRatio can be set.
+	DisplayAspectRatio AspectRatio
+	// Whether displays may crop the edges of the picture, unspecified by default.
+	Overscan Overscan
+	// Bits per sample of the encoded stream, 8 or 10, zero means 8.
+	// 10-bit input is read from Frame16 frames of a planar colorspace and requires a profile of at least high10.
+	BitDepth int
+	// How images of another size are fitted into frames, by default they are rejected.
+	ScaleMode ScaleMode
+	// Resampling filter of scaled images, bilinear by default.
+	ScaleFilter ScaleFilter
+	// Frame rate, zero means the x264 default of 25.
+	FrameRate int
+	// Frame rate denominator, the rate is FrameRate/FrameRateDen (e.g. 30000/1001), zero means 1.
+	FrameRateDen int
+	// Maximum interval between keyframes in frames, zero means one second.
+	KeyintMax int
+	// Minimum interval between IDR frames in frames, zero means auto.
+	KeyintMin int
+	// Timebase of frame timestamps as TimebaseNum/TimebaseDen seconds, zero means the frame duration.
+	TimebaseNum int
+	TimebaseDen int
+	// Variable frame rate input, rate control uses frame timestamps instead of the frame rate.
+	// Timestamps come from EncodeAt, the timebase defaults to 1/90000.
+	VFRInput bool
+	// Field order of interlaced encoding, progressive by default.
+	// Interlaced 4:2:0 frames are padded to a height that is a multiple of 4.
+	FieldOrder FieldOrder
+	// Code progressive frames but flag the stream as PAFF interlaced, e.g. for 25p and 30p Blu-ray.
+	FakeInterlaced bool
+	// Signal the EncodeOptions.PicStruct of every frame, e.g. the repeated fields of 3:2 pulldown.
+	// FrameRate is the output frame rate (30000/1001 for film pulled down from 24000/1001) and the timebase,
+	// if set, must be its frame duration. Frames without a timestamp last as many fields as their picture structure.
+	Pulldown bool
+	// Tunings: film, animation, grain, stillimage, psnr, ssim, fastdecode, zerolatency.
+	Tune string
+	// Presets: ultrafast, superfast, veryfast, faster, fast, medium, slow, slower, veryslow, placebo.
+	Preset string
+	// Profiles: baseline, main, high, high10, high422, high444.
+	Profile string
+	// Log level.
+	LogLevel int32
+	// Logger of x264 messages up to LogLevel, nil means x264 prints them to stderr.
+	Logger *log.Logger
+	// x264 options in the x264 CLI style, e.g. "keyint=120:bframes=3:ref=4:me=umh".
+	// Options are applied after the preset and the other fields, and before the profile, so they take precedence.
+	X264Params string
+	// x264 options by name, applied after X264Params. An empty value sets a flag option such as "no-cabac".
+	X264Options map[string]string
+
+	// Rate-control mode, CRF by default.
+	RateControl RateControl
+	// Constant rate factor [0-51] for RateControlCRF, zero means 28.
+	CRF float32
+	// Constant quantizer [0-51] for RateControlCQP, 0 is lossless.
+	QP int
+	// Target bitrate in kbit/s for RateControlABR and RateControlCBR.
+	Bitrate int
+	// VBV maximum bitrate in kbit/s, optional for CRF and ABR, defaults to Bitrate for CBR.
+	VBVMaxBitrate int
+	// VBV buffer size in kbit, required with VBVMaxBitrate, defaults to Bitrate for CBR.
+	VBVBufferSize int
+	// Initial VBV buffer occupancy, <=1: fraction of the buffer size, >1: kbit.
+	VBVInit float32
+	// Rate-control overrides for frame ranges, later zones take precedence where they overlap.
+	Zones []Zone
+
+	// Compute PSNR and SSIM of encoded frames for Encoder.Stats, this slows encoding down.
+	// Psychovisual optimizations of the preset and tune lower both metrics, "psnr" and "ssim" tunes turn them off.
+	PSNR bool
+	SSIM bool
+	// Called with the statistics of every encoded frame as it is returned by the encoder.
+	OnFrameStats func(FrameStats)
+
+	// Flush delayed frames to the writer in Encoder.Close.
+	FlushOnClose bool
+
+	// two-pass encoding set by TwoPassEncoder, the pass number and the stats file
+	pass  int
+	stats string
+}
+
+// Zone overrides rate control for a range of frames.
+type Zone struct {
+	// First and last frame of the zone, frames are counted from zero.
+	Start int
+	End   int
+	// Constant quantizer [0-51] of the zone, used when BitrateFactor is zero.
+	QP int
+	// Bitrate multiplier of the zone, e.g. 0.5 gives the zone half the bits.
+	BitrateFactor float32
+}
+
+// validateRateControl checks that rate-control options are consistent with each other.
+func (o *Options) validateRateControl() error {
+	if o.Bitrate < 0 || o.VBVMaxBitrate < 0 || o.VBVBufferSize < 0 || o.VBVInit < 0 {
+		return fmt.Errorf("%w: bitrate and VBV values must not be negative", ErrInvalidOptions)
+	}
+
+	switch o.RateControl {
+	case RateControlCRF:
+		if o.CRF < 0 || o.CRF > maxQP {
+			return fmt.Errorf("%w: CRF %v out of range [0-%d]", ErrInvalidOptions, o.CRF, maxQP)
+		}
+		if o.Bitrate != 0 {
+			return fmt.Errorf("%w: bitrate is not used with CRF rate control", ErrInvalidOptions)
+		}
+	case RateControlCQP:
+		if o.QP < 0 || o.QP > maxQP {
+			return fmt.Errorf("%w: QP %d out of range [0-%d]", ErrInvalidOptions, o.QP, maxQP)
+		}
+		if o.Bitrate != 0 || o.VBVMaxBitrate != 0 || o.VBVBufferSize != 0 {
+			return fmt.Errorf("%w: bitrate and VBV are not used with CQP rate control", ErrInvalidOptions)
+		}
+	case RateControlABR:
+		if o.Bitrate == 0 {
+			return fmt.Errorf("%w: ABR rate control requires bitrate", ErrInvalidOptions)
+		}
+	case RateControlCBR:
+		if o.Bitrate == 0 {
+			return fmt.Errorf("%w: CBR rate control requires bitrate", ErrInvalidOptions)
+		}
+		if o.VBVMaxBitrate != 0 && o.VBVMaxBitrate != o.Bitrate {
+			return fmt.Errorf("%w: CBR rate control requires VBV max bitrate equal to bitrate, got %d and %d", ErrInvalidOptions,
+				o.VBVMaxBitrate, o.Bitrate)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown rate control mode %d", ErrInvalidOptions, o.RateControl)
+	}
+
+	if (o.VBVMaxBitrate == 0) != (o.VBVBufferSize == 0) {
+		return fmt.Errorf("%w: VBV max bitrate and buffer size must be set together", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+// validateZones checks the rate-control zones.
+func (o *Options) validateZones() error {
+	for i, z := range o.Zones {
+		if z.Start < 0 || z.End < z.Start || int64(z.End) > math.MaxInt32 {
+			return fmt.Errorf("%w: zone %d has invalid frame range %d-%d", ErrInvalidOptions, i, z.Start, z.End)
+		}
+		if z.BitrateFactor < 0 {
+			return fmt.Errorf("%w: zone %d has negative bitrate factor %v", ErrInvalidOptions, i, z.BitrateFactor)
+		}
+		if z.BitrateFactor == 0 && (z.QP < 0 || z.QP > maxQP) {
+			return fmt.Errorf("%w: zone %d QP %d out of range [0-%d]", ErrInvalidOptions, i, z.QP, maxQP)
+		}
+	}
+
+	return nil
+}
+
+// validateSize checks the frame size.
+func (o *Options) validateSize() error {
+	if o.Width <= 0 || o.Height <= 0 || int64(o.Width) > math.MaxInt32 || int64(o.Height) > math.MaxInt32 {
+		return fmt.Errorf("%w: invalid frame size %dx%d", ErrInvalidOptions, o.Width, o.Height)
+	}
+	return nil
+}
+
+// frameRate returns the frame rate as a fraction, 0/0 if it is not set.
+func (o *Options) frameRate() (num, den int64) {
+	if o.FrameRate <= 0 {
+		return 0, 0
+	}
+
+	num, den = int64(o.FrameRate), int64(o.FrameRateDen)
+	if den == 0 {
+		den = 1
+	}
+
+	return
+}
+
+// validateTiming checks frame rate, keyframe interval and timebase options.
+func (o *Options) validateTiming() error {
+	if o.FrameRate < 0 || o.FrameRateDen < 0 {
+		return fmt.Errorf("%w: frame rate must not be negative", ErrInvalidOptions)
+	}
+	if int64(o.FrameRate) > math.MaxUint32 || int64(o.FrameRateDen) > math.MaxUint32 {
+		return fmt.Errorf("%w: frame rate %d/%d is too big", ErrInvalidOptions, o.FrameRate, o.FrameRateDen)
+	}
+
+	if o.KeyintMax < 0 || o.KeyintMin < 0 {
+		return fmt.Errorf("%w: keyframe interval must not be negative", ErrInvalidOptions)
+	}
+	if o.KeyintMax > 0 && o.KeyintMin > o.KeyintMax {
+		return fmt.Errorf("%w: minimum keyframe interval %d is greater than maximum %d",
+			ErrInvalidOptions, o.KeyintMin, o.KeyintMax)
+	}
+
+	if (o.TimebaseNum == 0) != (o.TimebaseDen == 0) {
+		return fmt.Errorf("%w: timebase numerator and denominator must be set together", ErrInvalidOptions)
+	}
+	if o.TimebaseNum < 0 || o.TimebaseDen < 0 {
+		return fmt.Errorf("%w: timebase must not be negative", ErrInvalidOptions)
+	}
+	// H.264 time_scale is twice the timebase denominator
+	if int64(o.TimebaseNum) > math.MaxUint32 || int64(o.TimebaseDen) > math.MaxUint32/2 {
+		return fmt.Errorf("%w: timebase %d/%d is too big", ErrInvalidOptions, o.TimebaseNum, o.TimebaseDen)
+	}
+
+	if num, den := o.frameRate(); num > 0 && o.TimebaseNum > 0 {
+		// every frame should get a distinct timestamp
+		if int64(o.TimebaseDen)*den < int64(o.TimebaseNum)*num {
+			return fmt.Errorf("%w: timebase %d/%d is coarser than the frame duration %d/%d", ErrInvalidOptions,
+				o.TimebaseNum, o.TimebaseDen, den, num)
+		}
+	}
+
+	return nil
+}
+
+// x264Options returns the x264 options in the order they are applied.
+func (o *Options) x264Options() (names, values []string) {
+	for _, opt := range strings.Split(o.X264Params, ":") {
+		if opt == "" {
+			continue
+		}
+
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		names, values = append(names, kv[0]), append(values, flagValue(kv[1]))
+	}
+
+	keys := make([]string, 0, len(o.X264Options))
+	for k := range o.X264Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		names, values = append(names, k), append(values, flagValue(o.X264Options[k]))
+	}
+
+	return
+}
+
+// flagValue returns the value of an x264 option, an empty value enables a flag.
+func flagValue(v string) string {
+	if v == "" {
+		return "true"
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package x264
+
+import "time"
+
+// NalType is a NAL unit type.
+type NalType int
+
+// NAL unit types.
+const (
+	NalUnknown  NalType = 0
+	NalSlice    NalType = 1
+	NalSliceDpa NalType = 2
+	NalSliceDpb NalType = 3
+	NalSliceDpc NalType = 4
+	NalSliceIdr NalType = 5
+	NalSei      NalType = 6
+	NalSps      NalType = 7
+	NalPps      NalType = 8
+	NalAud      NalType = 9
+	NalFiller   NalType = 12
+)
+
+// NalPriority is a NAL unit reference priority (nal_ref_idc).
+type NalPriority int
+
+// NAL unit priorities.
+const (
+	NalPriorityDisposable NalPriority = iota
+	NalPriorityLow
+	NalPriorityHigh
+	NalPriorityHighest
+)
+
+// FrameType is the type of an encoded frame.
+type FrameType int
+
+// Frame types.
+const (
+	FrameTypeAuto FrameType = iota
+	FrameTypeIDR
+	FrameTypeI
+	FrameTypeP
+	// Non-disposable B-frame.
+	FrameTypeBref
+	FrameTypeB
+)
+
+// String returns the frame type name.
+func (t FrameType) String() string {
+	switch t {
+	case FrameTypeIDR:
+		return "IDR"
+	case FrameTypeI:
+		return "I"
+	case FrameTypeP:
+		return "P"
+	case FrameTypeBref:
+		return "Bref"
+	case FrameTypeB:
+		return "B"
+	}
+	return "auto"
+}
+
+// Nal is a NAL unit of an encoded access unit.
+type Nal struct {
+	Type   NalType
+	RefIdc NalPriority
+	// Annex B NAL unit including the start code.
+	Payload []byte
+}
+
+// Packet is one encoded access unit.
+type Packet struct {
+	// NAL units of the access unit, payloads share one buffer.
+	Nals []Nal
+	// Presentation and decoding timestamps in timebase ticks.
+	// Decoding timestamps of the first frames may be negative with B-frames.
+	Pts int64
+	Dts int64
+	// Type of the encoded frame.
+	Type FrameType
+	// Whether the frame is a keyframe (IDR or a recovery point).
+	Keyframe bool
+
+	data []byte
+}
+
+// Bytes returns the Annex B bytes of the whole access unit.
+func (p *Packet) Bytes() []byte {
+	return p.data
+}
+
+// EncodeOptions are options of a single frame.
+type EncodeOptions struct {
+	// Presentation time of the frame, see EncodeAt.
+	// Zero means the next frame at the configured frame rate.
+	Pts time.Duration
+	// Encode the frame as an IDR frame.
+	ForceIDR bool
+	// How the frame is displayed, auto by default. Structures other than auto require
+	// an interlaced stream or Options.Pulldown, and repeated fields require Options.Pulldown.
+	PicStruct PicStruct
+	// Messages written in the access unit of the frame, e.g. UserDataUnregistered.
+	// The payloads are copied when the frame is submitted to x264.
+	SEI []SEIMessage
+}
@@ -0,0 +1,223 @@
+package x264
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Params are the rate-control parameters of a running encoder, see Encoder.Reconfigure.
+//
+// x264 can change only some of them while encoding:
+// CRF with RateControlCRF, and Bitrate, VBVMaxBitrate and VBVBufferSize when VBV was
+// enabled when the encoder was opened (always with RateControlCBR).
+// RateControl and QP are fixed.
+type Params struct {
+	RateControl   RateControl
+	CRF           float32
+	QP            int
+	Bitrate       int
+	VBVMaxBitrate int
+	VBVBufferSize int
+}
+
+// vbv reports whether VBV is enabled.
+func (p *Params) vbv() bool {
+	return p.VBVMaxBitrate > 0 && p.VBVBufferSize > 0
+}
+
+// rateParams returns the rate-control parameters of an opened encoder,
+// ABR with the VBV max bitrate equal to the bitrate is CBR.
+func rateParams(param *x264Param) Params {
+	p := Params{
+		CRF:           param.Rc.FRfConstant,
+		Bitrate:       int(param.Rc.IBitrate),
+		VBVMaxBitrate: int(param.Rc.IVbvMaxBitrate),
+		VBVBufferSize: int(param.Rc.IVbvBufferSize),
+	}
+
+	switch param.Rc.IRcMethod {
+	case rcCqp:
+		p.RateControl = RateControlCQP
+		p.QP = int(param.Rc.IQpConstant)
+	case rcAbr:
+		p.RateControl = RateControlABR
+		if p.vbv() && p.VBVMaxBitrate == p.Bitrate {
+			p.RateControl = RateControlCBR
+		}
+	default:
+		p.RateControl = RateControlCRF
+	}
+
+	return p
+}
+
+// Params returns the current rate-control parameters.
+func (e *Encoder) Params() Params {
+	return e.params
+}
+
+// Reconfigure changes rate-control parameters while encoding, fn modifies a copy of the current ones.
+// It returns the x264 names of the parameters that changed (crf, bitrate, vbv-maxrate, vbv-bufsize),
+// the changes take effect from the next encoded frame.
+// An error is returned, and nothing is changed, when a parameter cannot be changed at runtime.
+//
+// With RateControlCBR the VBV max bitrate follows Bitrate unless it is changed as well.
+func (e *Encoder) Reconfigure(fn func(*Params)) ([]string, error) {
+	if e.state == stateClosed {
+		return nil, ErrClosed
+	}
+
+	old := e.params
+	p := old
+	fn(&p)
+
+	if p.RateControl == RateControlCBR && p.Bitrate != old.Bitrate && p.VBVMaxBitrate == old.VBVMaxBitrate {
+		p.VBVMaxBitrate = p.Bitrate
+	}
+
+	if err := old.validateChange(&p); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	if p.CRF != old.CRF {
+		changed = append(changed, "crf")
+	}
+	if p.Bitrate != old.Bitrate {
+		changed = append(changed, "bitrate")
+	}
+	if p.VBVMaxBitrate != old.VBVMaxBitrate {
+		changed = append(changed, "vbv-maxrate")
+	}
+	if p.VBVBufferSize != old.VBVBufferSize {
+		changed = append(changed, "vbv-bufsize")
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	param := x264Param{}
+	encoderParameters(e.e, &param)
+
+	// every managed field is set, so a reconfiguration still pending in x264 is not undone
+	param.Rc.FRfConstant = p.CRF
+	param.Rc.IBitrate = int32(p.Bitrate)
+	param.Rc.IVbvMaxBitrate = int32(p.VBVMaxBitrate)
+	param.Rc.IVbvBufferSize = int32(p.VBVBufferSize)
+
+	if encoderReconfig(e.e, &param) < 0 {
+		return nil, ErrReconfigure
+	}
+
+	e.params = p
+
+	return changed, nil
+}
+
+// Resize changes the frame size by reopening the encoder with the same options and the current Params.
+// Delayed frames are flushed to the writer first. Without a writer they would be lost, so callers using packets
+// must drain them with FlushFrame, otherwise ErrDelayedFrames is returned.
+// The new SPS and PPS are written to the writer and returned by Headers, and the next frame is an IDR frame.
+// Timestamps, frame numbers and statistics continue across the switch, rate-control zones start over.
+// When the encoder cannot be opened with the new size, it is left as it was.
+func (e *Encoder) Resize(width, height int) error {
+	if e.state == stateClosed {
+		return ErrClosed
+	}
+	if width == e.opts.Width && height == e.opts.Height {
+		return nil
+	}
+	if e.w == nil && encoderDelayedFrames(e.e) > 0 {
+		return ErrDelayedFrames
+	}
+
+	opts := *e.opts
+	opts.Width, opts.Height = width, height
+	opts.RateControl = e.params.RateControl
+	opts.CRF = e.params.CRF
+	opts.QP = e.params.QP
+	opts.Bitrate = e.params.Bitrate
+	opts.VBVMaxBitrate = e.params.VBVMaxBitrate
+	opts.VBVBufferSize = e.params.VBVBufferSize
+
+	// headers are written after the flushed frames
+	n, err := NewEncoder(nil, &opts)
+	if err != nil {
+		return err
+	}
+
+	if err = e.Flush(); err != nil {
+		n.Close()
+		return err
+	}
+	e.close()
+
+	n.w = e.w
+	n.pts, n.basePts, n.fields, n.frames = e.pts, e.basePts, e.fields, e.frames
+	n.stats = e.stats
+
+	// the encoder takes over the new one, which must not be finalized
+	runtime.SetFinalizer(n, nil)
+	*e = *n
+	runtime.SetFinalizer(e, (*Encoder).finalize)
+
+	if e.headers != nil {
+		return e.write(e.headers.data)
+	}
+	return nil
+}
+
+// SetCRF changes the constant rate factor of RateControlCRF.
+func (e *Encoder) SetCRF(crf float32) error {
+	_, err := e.Reconfigure(func(p *Params) { p.CRF = crf })
+	return err
+}
+
+// SetBitrate changes the target bitrate in kbit/s, VBV must be enabled.
+func (e *Encoder) SetBitrate(bitrate int) error {
+	_, err := e.Reconfigure(func(p *Params) { p.Bitrate = bitrate })
+	return err
+}
+
+// validateChange checks that the parameters can be changed from p to n at runtime.
+func (p *Params) validateChange(n *Params) error {
+	if n.RateControl != p.RateControl {
+		return fmt.Errorf("%w: rate control mode cannot be changed while encoding", ErrInvalidOptions)
+	}
+	if n.QP != p.QP {
+		return fmt.Errorf("%w: QP cannot be changed while encoding", ErrInvalidOptions)
+	}
+
+	if n.CRF != p.CRF {
+		if p.RateControl != RateControlCRF {
+			return fmt.Errorf("%w: CRF can be changed only with CRF rate control", ErrInvalidOptions)
+		}
+		if n.CRF <= 0 || n.CRF > maxQP {
+			return fmt.Errorf("%w: CRF %v out of range (0-%d]", ErrInvalidOptions, n.CRF, maxQP)
+		}
+	}
+
+	if n.Bitrate != p.Bitrate || n.VBVMaxBitrate != p.VBVMaxBitrate || n.VBVBufferSize != p.VBVBufferSize {
+		if !p.vbv() {
+			return fmt.Errorf("%w: bitrate and VBV can be changed only when VBV is enabled", ErrInvalidOptions)
+		}
+		if n.VBVMaxBitrate <= 0 || n.VBVBufferSize <= 0 {
+			return fmt.Errorf("%w: VBV cannot be disabled while encoding", ErrInvalidOptions)
+		}
+		if n.Bitrate < 0 {
+			return fmt.Errorf("%w: bitrate must not be negative", ErrInvalidOptions)
+		}
+		if p.RateControl == RateControlCRF && n.Bitrate != p.Bitrate {
+			return fmt.Errorf("%w: bitrate is not used with CRF rate control", ErrInvalidOptions)
+		}
+		if (p.RateControl == RateControlABR || p.RateControl == RateControlCBR) && n.Bitrate == 0 {
+			return fmt.Errorf("%w: bitrate is required with ABR and CBR rate control", ErrInvalidOptions)
+		}
+		if p.RateControl == RateControlCBR && n.VBVMaxBitrate != n.Bitrate {
+			return fmt.Errorf("%w: CBR rate control requires VBV max bitrate equal to bitrate, got %d and %d", ErrInvalidOptions,
+				n.VBVMaxBitrate, n.Bitrate)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package x264
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ScaleMode is how images of a size other than the encoder size are fitted into frames.
+type ScaleMode int
+
+// Scale modes.
+const (
+	// Reject the image with a *SizeError, the default. Empty images are rejected in every mode.
+	ScaleReject ScaleMode = iota
+	// Scale the image to the frame size, ignoring its aspect ratio.
+	ScaleStretch
+	// Scale the image to fit inside the frame keeping its aspect ratio, black bars fill the rest.
+	ScaleFit
+	// Scale the image to cover the frame keeping its aspect ratio, the overflow is cropped evenly.
+	ScaleFill
+)
+
+// ScaleFilter is the resampling filter of scaled images.
+type ScaleFilter int
+
+// Resampling filters.
+const (
+	// Bilinear interpolation, the default.
+	FilterBilinear ScaleFilter = iota
+	// Nearest neighbor, the fastest and the blockiest.
+	FilterNearest
+	// Catmull-Rom cubic, the sharpest and the slowest.
+	FilterCatmullRom
+)
+
+// kernel is a resampling kernel, at is zero outside [-support, support].
+type kernel struct {
+	support float64
+	at      func(t float64) float64
+}
+
+var kernels = map[ScaleFilter]kernel{
+	FilterBilinear: {1, func(t float64) float64 {
+		return 1 - math.Abs(t)
+	}},
+	FilterCatmullRom: {2, func(t float64) float64 {
+		t = math.Abs(t)
+		if t < 1 {
+			return (1.5*t-2.5)*t*t + 1
+		}
+		return ((-0.5*t+2.5)*t-4)*t + 2
+	}},
+}
+
+// validateScale checks the scale mode and filter.
+func (o *Options) validateScale() error {
+	if o.ScaleMode < ScaleReject || o.ScaleMode > ScaleFill {
+		return fmt.Errorf("%w: unknown scale mode %d", ErrInvalidOptions, o.ScaleMode)
+	}
+	if o.ScaleFilter < FilterBilinear || o.ScaleFilter > FilterCatmullRom {
+		return fmt.Errorf("%w: unknown scale filter %d", ErrInvalidOptions, o.ScaleFilter)
+	}
+	return nil
+}
+
+// scaleRects returns the part of the source that is scaled and the part of the frame it is scaled to.
+func scaleRects(mode ScaleMode, src, frame image.Rectangle) (sr, dr image.Rectangle) {
+	sr, dr = src, frame
+	sw, sh := float64(src.Dx()), float64(src.Dy())
+	fw, fh := float64(frame.Dx()), float64(frame.Dy())
+
+	switch mode {
+	case ScaleFit:
+		s := math.Min(fw/sw, fh/sh)
+		w, h := clampSize(sw*s, frame.Dx()), clampSize(sh*s, frame.Dy())
+		dr.Min = frame.Min.Add(image.Pt((frame.Dx()-w)/2, (frame.Dy()-h)/2))
+		dr.Max = dr.Min.Add(image.Pt(w, h))
+	case ScaleFill:
+		s := math.Max(fw/sw, fh/sh)
+		w, h := clampSize(fw/s, src.Dx()), clampSize(fh/s, src.Dy())
+		sr.Min = src.Min.Add(image.Pt((src.Dx()-w)/2, (src.Dy()-h)/2))
+		sr.Max = sr.Min.Add(image.Pt(w, h))
+	}
+
+	return
+}
+
+// clampSize rounds v to a size in [1, max].
+func clampSize(v float64, max int) int {
+	n := int(math.Round(v))
+	if n < 1 {
+		return 1
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// scaler resamples images into frames, it keeps the buffers and weights between frames of the same size.
+type scaler struct {
+	filter ScaleFilter
+
+	sr, dr image.Rectangle
+	// weights of the source columns and rows of every frame column and row
+	xw, yw [][]weight
+
+	// source row and horizontally scaled rows as premultiplied RGBA
+	row []float32
+	tmp []float32
+}
+
+// weight is the contribution of the source pixel at index i.
+type weight struct {
+	i int
+	w float32
+}
+
+// scale resamples the source rectangle of im into the frame rectangle of dst.
+func (s *scaler) scale(dst draw.Image, dr image.Rectangle, im image.Image, sr image.Rectangle) {
+	if sr != s.sr || dr != s.dr {
+		s.sr, s.dr = sr, dr
+		s.xw = s.weights(sr.Min.X, sr.Dx(), dr.Dx())
+		s.yw = s.weights(sr.Min.Y, sr.Dy(), dr.Dy())
+		s.row = make([]float32, sr.Dx()*4)
+		s.tmp = make([]float32, dr.Dx()*sr.Dy()*4)
+	}
+
+	// horizontal pass, every source row to the frame width
+	dw := dr.Dx()
+	for y := 0; y < sr.Dy(); y++ {
+		readRow(s.row, im, sr.Min.X, sr.Max.X, sr.Min.Y+y)
+
+		out := s.tmp[y*dw*4 : (y+1)*dw*4]
+		for x, ws := range s.xw {
+			var r, g, b, a float32
+			for _, w := range ws {
+				p := s.row[(w.i-sr.Min.X)*4:]
+				r += p[0] * w.w
+				g += p[1] * w.w
+				b += p[2] * w.w
+				a += p[3] * w.w
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, b, a
+		}
+	}
+
+	// vertical pass, the scaled rows to the frame height
+	for y, ws := range s.yw {
+		for x := 0; x < dw; x++ {
+			var r, g, b, a float32
+			for _, w := range ws {
+				p := s.tmp[((w.i-sr.Min.Y)*dw+x)*4:]
+				r += p[0] * w.w
+				g += p[1] * w.w
+				b += p[2] * w.w
+				a += p[3] * w.w
+			}
+
+			c := color.RGBA64{R: clamp16(r), G: clamp16(g), B: clamp16(b), A: clamp16(a)}
+			// premultiplied components can't exceed alpha
+			c.R, c.G, c.B = minU16(c.R, c.A), minU16(c.G, c.A), minU16(c.B, c.A)
+			dst.Set(dr.Min.X+x, dr.Min.Y+y, c)
+		}
+	}
+}
+
+// weights returns the source weights of n destination samples scaled from size samples starting at min.
+func (s *scaler) weights(min, size, n int) [][]weight {
+	ws := make([][]weight, n)
+	scale := float64(size) / float64(n)
+
+	k, ok := kernels[s.filter]
+	if !ok {
+		// nearest neighbor
+		for i := range ws {
+			j := int((float64(i) + 0.5) * scale)
+			if j >= size {
+				j = size - 1
+			}
+			ws[i] = []weight{{i: min + j, w: 1}}
+		}
+		return ws
+	}
+
+	// the kernel is stretched when downscaling, so every source sample contributes
+	width := math.Max(scale, 1)
+	support := k.support * width
+
+	for i := range ws {
+		center := (float64(i)+0.5)*scale - 0.5
+		var sum float64
+		for j := int(math.Ceil(center - support)); j <= int(math.Floor(center+support)); j++ {
+			w := k.at((float64(j) - center) / width)
+			if w == 0 {
+				continue
+			}
+
+			// edge samples are repeated
+			idx := j
+			if idx < 0 {
+				idx = 0
+			} else if idx >= size {
+				idx = size - 1
+			}
+
+			ws[i] = append(ws[i], weight{i: min + idx, w: float32(w)})
+			sum += w
+		}
+		for j := range ws[i] {
+			ws[i][j].w /= float32(sum)
+		}
+	}
+
+	return ws
+}
+
+// readRow reads the pixels of row y between x0 and x1 as premultiplied RGBA.
+func readRow(row []float32, im image.Image, x0, x1, y int) {
+	if rgba, ok := im.(*image.RGBA); ok {
+		p := rgba.Pix[rgba.PixOffset(x0, y):]
+		for i := range row[:(x1-x0)*4] {
+			row[i] = float32(p[i]) * 0x101
+		}
+		return
+	}
+
+	for x := x0; x < x1; x++ {
+		r, g, b, a := im.At(x, y).RGBA()
+		i := (x - x0) * 4
+		row[i], row[i+1], row[i+2], row[i+3] = float32(r), float32(g), float32(b), float32(a)
+	}
+}
+
+func clamp16(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 0xffff {
+		return 0xffff
+	}
+	return uint16(v + 0.5)
+}
+
+func minU16(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
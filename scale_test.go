@@ -0,0 +1,75 @@
+package x264
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestScaleRects(t *testing.T) {
+	frame := image.Rect(0, 0, 320, 240)
+
+	tests := []struct {
+		mode   ScaleMode
+		src    image.Rectangle
+		sr, dr image.Rectangle
+	}{
+		{ScaleStretch, image.Rect(0, 0, 640, 360), image.Rect(0, 0, 640, 360), frame},
+		{ScaleFit, image.Rect(0, 0, 640, 360), image.Rect(0, 0, 640, 360), image.Rect(0, 30, 320, 210)},
+		{ScaleFit, image.Rect(10, 10, 130, 250), image.Rect(10, 10, 130, 250), image.Rect(100, 0, 220, 240)},
+		{ScaleFill, image.Rect(0, 0, 640, 360), image.Rect(80, 0, 560, 360), frame},
+		{ScaleFill, image.Rect(0, 0, 160, 240), image.Rect(0, 60, 160, 180), frame},
+		{ScaleFit, image.Rect(0, 0, 2000, 1), image.Rect(0, 0, 2000, 1), image.Rect(0, 119, 320, 120)},
+	}
+
+	for _, test := range tests {
+		sr, dr := scaleRects(test.mode, test.src, frame)
+		if sr != test.sr || dr != test.dr {
+			t.Errorf("mode %d %v: got %v %v, want %v %v", test.mode, test.src, sr, dr, test.sr, test.dr)
+		}
+	}
+}
+
+func TestScalerFilters(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			// black and white stripes one pixel wide
+			v := uint8(255 * (x % 2))
+			src.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	for _, filter := range []ScaleFilter{FilterBilinear, FilterNearest, FilterCatmullRom} {
+		s := &scaler{filter: filter}
+
+		// upscaling keeps the stripes two pixels wide
+		up := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		s.scale(up, up.Rect, src, src.Rect)
+		if filter == FilterNearest {
+			for x := 0; x < 16; x++ {
+				if got, want := up.RGBAAt(x, 5).R, uint8(255*(x/2%2)); got != want {
+					t.Errorf("nearest: pixel %d: got %d, want %d", x, got, want)
+				}
+			}
+		}
+		for x := 0; x < 16; x++ {
+			if c := up.RGBAAt(x, 5); c.R != c.G || c.G != c.B || c.A != 255 {
+				t.Errorf("filter %d: pixel %d: got %v, want opaque gray", filter, x, c)
+			}
+		}
+
+		// downscaling averages the stripes away from the repeated edges, nearest picks one of them
+		down := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		s.scale(down, down.Rect, src, src.Rect)
+		for x := 1; x < 3; x++ {
+			c := down.RGBAAt(x, 2)
+			if filter != FilterNearest && (c.R < 120 || c.R > 135) {
+				t.Errorf("filter %d: pixel %d: got %v, want mid gray", filter, x, c)
+			}
+			if filter == FilterNearest && c.R != 0 && c.R != 255 {
+				t.Errorf("nearest: pixel %d: got %v, want black or white", x, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package x264
+
+import "fmt"
+
+// SEI payload types of user data, see H.264 Annex D.
+const (
+	SEIUserDataRegistered   = 4
+	SEIUserDataUnregistered = 5
+)
+
+// SEIMessage is a supplemental enhancement information message written in the access unit of a frame.
+type SEIMessage struct {
+	// Payload type, e.g. SEIUserDataUnregistered.
+	Type int
+	// Payload in the syntax of the type, with the alignment bits but without emulation prevention bytes.
+	Payload []byte
+}
+
+// UserDataUnregistered returns a user_data_unregistered message of data tagged with the UUID.
+func UserDataUnregistered(uuid [16]byte, data []byte) SEIMessage {
+	payload := make([]byte, 0, len(uuid)+len(data))
+	payload = append(payload, uuid[:]...)
+	payload = append(payload, data...)
+
+	return SEIMessage{Type: SEIUserDataUnregistered, Payload: payload}
+}
+
+// UserDataRegistered returns a user_data_registered_itu_t_t35 message of data registered by ITU-T T.35,
+// e.g. 0xb5 for the United States. Data usually starts with the provider code, it starts with
+// the country code extension byte when the country code is 0xff.
+func UserDataRegistered(country byte, data []byte) SEIMessage {
+	payload := make([]byte, 0, 1+len(data))
+	payload = append(payload, country)
+	payload = append(payload, data...)
+
+	return SEIMessage{Type: SEIUserDataRegistered, Payload: payload}
+}
+
+// validateSEI checks the messages of a frame.
+func validateSEI(msgs []SEIMessage) error {
+	for i, m := range msgs {
+		switch {
+		case m.Type < 0:
+			return fmt.Errorf("%w: SEI message %d has negative type %d", ErrInvalidOptions, i, m.Type)
+		case m.Type == SEIUserDataUnregistered && len(m.Payload) < 16:
+			return fmt.Errorf("%w: SEI message %d of unregistered user data is shorter than its UUID", ErrInvalidOptions, i)
+		case m.Type == SEIUserDataRegistered && len(m.Payload) < 1:
+			return fmt.Errorf("%w: SEI message %d of registered user data has no country code", ErrInvalidOptions, i)
+		}
+	}
+
+	return nil
+}
+
+// setSEI copies the messages to C memory of the input picture, x264 frees it after writing them.
+func (e *Encoder) setSEI(msgs []SEIMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	types := make([]int32, len(msgs))
+	payloads := make([][]byte, len(msgs))
+	for i, m := range msgs {
+		types[i], payloads[i] = int32(m.Type), m.Payload
+	}
+
+	if !seiSet(&e.picIn.ExtraSei, types, payloads) {
+		return ErrAlloc
+	}
+	return nil
+}
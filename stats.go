@@ -0,0 +1,142 @@
+package x264
+
+// FrameStats are statistics of an encoded frame.
+type FrameStats struct {
+	// Presentation timestamp in timebase ticks.
+	Pts int64
+	// Type of the encoded frame.
+	Type     FrameType
+	Keyframe bool
+	// Size of the access unit in bytes, including repeated headers.
+	Size int
+	// Frame quantizer.
+	QP int
+	// Average effective rate factor with RateControlCRF.
+	CRF float64
+	// PSNR of the Y, U and V planes and their average, with Options.PSNR.
+	PSNR    [3]float64
+	PSNRAvg float64
+	// Luma SSIM, with Options.SSIM.
+	SSIM float64
+}
+
+// TypeStats are cumulative statistics of frames of one type.
+type TypeStats struct {
+	Frames int64
+	Bytes  int64
+	// Average frame quantizer.
+	QP float64
+}
+
+// Stats are cumulative encoding statistics of the frames returned so far.
+type Stats struct {
+	Frames    int64
+	Keyframes int64
+	Bytes     int64
+	// Statistics by frame type, I includes IDR frames and B includes reference B-frames.
+	I, P, B TypeStats
+	// Averages of the frame statistics.
+	QP      float64
+	CRF     float64
+	PSNR    [3]float64
+	PSNRAvg float64
+	SSIM    float64
+}
+
+// statsSum accumulates frame statistics.
+type statsSum struct {
+	frames, keyframes, bytes int64
+	types                    [3]struct{ frames, bytes, qp int64 }
+	qp                       int64
+	crf, psnrAvg, ssim       float64
+	psnr                     [3]float64
+}
+
+// add adds the statistics of a frame.
+func (s *statsSum) add(f *FrameStats) {
+	s.frames++
+	if f.Keyframe {
+		s.keyframes++
+	}
+	s.bytes += int64(f.Size)
+	s.qp += int64(f.QP)
+	s.crf += f.CRF
+	s.psnrAvg += f.PSNRAvg
+	s.ssim += f.SSIM
+	for i := range f.PSNR {
+		s.psnr[i] += f.PSNR[i]
+	}
+
+	t := &s.types[typeIndex(f.Type)]
+	t.frames++
+	t.bytes += int64(f.Size)
+	t.qp += int64(f.QP)
+}
+
+// stats returns the totals and averages.
+func (s *statsSum) stats() Stats {
+	st := Stats{Frames: s.frames, Keyframes: s.keyframes, Bytes: s.bytes}
+
+	types := []*TypeStats{&st.I, &st.P, &st.B}
+	for i, t := range s.types {
+		*types[i] = TypeStats{Frames: t.frames, Bytes: t.bytes}
+		if t.frames > 0 {
+			types[i].QP = float64(t.qp) / float64(t.frames)
+		}
+	}
+
+	if s.frames > 0 {
+		n := float64(s.frames)
+		st.QP = float64(s.qp) / n
+		st.CRF = s.crf / n
+		st.PSNRAvg = s.psnrAvg / n
+		st.SSIM = s.ssim / n
+		for i := range s.psnr {
+			st.PSNR[i] = s.psnr[i] / n
+		}
+	}
+
+	return st
+}
+
+// typeIndex returns the index of the I, P or B group of a frame type.
+func typeIndex(t FrameType) int {
+	switch t {
+	case FrameTypeP:
+		return 1
+	case FrameTypeB, FrameTypeBref:
+		return 2
+	}
+	return 0
+}
+
+// Stats returns cumulative statistics of the encoded frames.
+func (e *Encoder) Stats() Stats {
+	return e.stats.stats()
+}
+
+// collect records the statistics of the frame returned by the last x264 call of size bytes.
+func (e *Encoder) collect(size int32) {
+	if size <= 0 {
+		return
+	}
+
+	pic := e.picOut
+	f := FrameStats{
+		Pts:      pic.IPts,
+		Type:     FrameType(pic.IType),
+		Keyframe: pic.BKeyframe != 0,
+		Size:     int(size),
+		QP:       int(pic.IQpplus1) - 1,
+		CRF:      pic.Prop.FCrfAvg,
+		PSNR:     pic.Prop.FPsnr,
+		PSNRAvg:  pic.Prop.FPsnrAvg,
+		SSIM:     pic.Prop.FSsim,
+	}
+
+	e.stats.add(&f)
+
+	if e.opts.OnFrameStats != nil {
+		e.opts.OnFrameStats(f)
+	}
+}
@@ -0,0 +1,178 @@
+package x264
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// FrameSource is a sequence of frames that can be read more than once.
+type FrameSource interface {
+	// Reset rewinds the source to the first frame.
+	Reset() error
+	// Next returns the next frame, io.EOF after the last one.
+	Next() (image.Image, error)
+}
+
+// TwoPassOptions represent two-pass encoding options.
+type TwoPassOptions struct {
+	// Encoding options, rate control is set from the target, so RateControl, CRF, QP and Bitrate must not be set.
+	// VBV options apply to the second pass. Frames are encoded at the constant frame rate, VFRInput is not supported.
+	Options
+	// Target bitrate in kbit/s.
+	TargetBitrate int
+	// Target size of the encoded stream in bytes, used when TargetBitrate is not set.
+	FileSize int64
+	// Path of the stats file, zero means a temporary file removed after encoding.
+	// x264 writes macroblock-tree stats next to it with the .mbtree suffix.
+	StatsFile string
+}
+
+// TwoPassEncoder encodes a frame source in two passes.
+// The first pass runs with fast settings and collects frame statistics,
+// the second one uses them to distribute bits and hit the target bitrate or size.
+type TwoPassEncoder struct {
+	w    io.Writer
+	opts TwoPassOptions
+}
+
+// NewTwoPassEncoder returns new two-pass encoder writing the second pass to w.
+func NewTwoPassEncoder(w io.Writer, opts *TwoPassOptions) (*TwoPassEncoder, error) {
+	if (opts.TargetBitrate > 0) == (opts.FileSize > 0) {
+		return nil, fmt.Errorf("%w: two-pass encoding requires either target bitrate or file size", ErrInvalidOptions)
+	}
+	if opts.TargetBitrate < 0 || opts.FileSize < 0 {
+		return nil, fmt.Errorf("%w: target bitrate and file size must not be negative", ErrInvalidOptions)
+	}
+	if int64(opts.TargetBitrate) > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: target bitrate %d is too big", ErrInvalidOptions, opts.TargetBitrate)
+	}
+	if opts.RateControl != RateControlCRF || opts.CRF != 0 || opts.QP != 0 || opts.Bitrate != 0 {
+		return nil, fmt.Errorf("%w: two-pass rate control is set from the target, not from the options", ErrInvalidOptions)
+	}
+	if opts.VFRInput {
+		return nil, fmt.Errorf("%w: two-pass encoding does not support VFR input", ErrInvalidOptions)
+	}
+	if err := opts.validateSize(); err != nil {
+		return nil, err
+	}
+	if err := opts.validateTiming(); err != nil {
+		return nil, err
+	}
+
+	return &TwoPassEncoder{w: w, opts: *opts}, nil
+}
+
+// Encode encodes the source twice, the source is reset before each pass.
+// The output of the first pass is discarded.
+func (t *TwoPassEncoder) Encode(src FrameSource) error {
+	stats := t.opts.StatsFile
+	if stats == "" {
+		dir, err := ioutil.TempDir("", "x264")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		stats = filepath.Join(dir, "x264_2pass.log")
+	}
+
+	first := t.opts.Options
+	first.pass, first.stats = 1, stats
+	first.Bitrate = t.opts.TargetBitrate
+	first.RateControl = RateControlABR
+	if first.Bitrate == 0 {
+		// the bitrate is not known before the frames are counted
+		first.RateControl = RateControlCRF
+	}
+
+	frames, err := encodePass(nil, &first, src)
+	if err != nil {
+		return err
+	}
+	if frames == 0 {
+		return ErrEmptySource
+	}
+
+	second := t.opts.Options
+	second.pass, second.stats = 2, stats
+	second.RateControl = RateControlABR
+	second.Bitrate = t.opts.TargetBitrate
+	if second.Bitrate == 0 {
+		if second.Bitrate, err = t.sizeBitrate(frames); err != nil {
+			return err
+		}
+	}
+
+	_, err = encodePass(t.w, &second, src)
+	return err
+}
+
+// sizeBitrate returns the bitrate in kbit/s for the target file size of frames.
+func (t *TwoPassEncoder) sizeBitrate(frames int64) (int, error) {
+	num, den := t.opts.frameRate()
+	if num == 0 {
+		num, den = 25, 1
+	}
+
+	// size * 8 / (frames * den / num) / 1000
+	kbps := float64(t.opts.FileSize) * 8 * float64(num) / (float64(frames) * float64(den) * 1000)
+	if kbps < 1 || kbps > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: file size %d is out of range for %d frames", ErrInvalidOptions, t.opts.FileSize, frames)
+	}
+
+	return int(kbps), nil
+}
+
+// encodePass encodes the source from the start, it returns the number of frames.
+func encodePass(w io.Writer, opts *Options, src FrameSource) (frames int64, err error) {
+	if err = src.Reset(); err != nil {
+		return
+	}
+
+	enc, err := NewEncoder(w, opts)
+	if err != nil {
+		return
+	}
+	// the stats file is complete when the encoder is closed
+	defer func() {
+		if er := enc.Close(); err == nil {
+			err = er
+		}
+	}()
+
+	for {
+		im, er := src.Next()
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			err = er
+			return
+		}
+
+		if err = enc.Encode(im); err != nil {
+			return
+		}
+		frames++
+	}
+
+	err = enc.Flush()
+	return
+}
+
+// applyPass sets the two-pass mode and the stats file.
+func applyPass(param *x264Param, pass int, stats string) error {
+	if paramParse(param, "pass", strconv.Itoa(pass)) < 0 || paramParse(param, "stats", stats) < 0 {
+		return fmt.Errorf("%w: cannot set two-pass parameters", ErrOpen)
+	}
+	if pass == 1 {
+		paramApplyFastfirstpass(param)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package x264
+
+import (
+	"fmt"
+	imgcolor "image/color"
+	"math"
+
+	"github.com/sergystepanov/x264-go/v2/x264c/color"
+)
+
+// Color is the color description of the encoded video, it is signaled in the SPS VUI.
+// Images are converted to the Matrix and Range, so the stream describes them exactly, Go YCbCr images
+// are JFIF and are converted as well unless the Color is their BT.601 matrix in the full range, the zero value.
+// Frames passed to x264 as is must hold samples of the Matrix and Range.
+type Color struct {
+	// Chromaticity of the primaries, unspecified by default.
+	Primaries ColorPrimaries
+	// Transfer characteristics, unspecified by default.
+	Transfer ColorTransfer
+	// Matrix of the YCbCr colorspaces, BT.601 by default, RGB colorspaces are always GBR.
+	Matrix ColorMatrix
+	// Sample range, full by default, RGB colorspaces are always full range.
+	Range ColorRange
+	// Location of the 4:2:0 chroma samples, left by default.
+	ChromaLocation ChromaLocation
+}
+
+// ColorPrimaries is the chromaticity of the primaries, see H.264 Table E-3.
+type ColorPrimaries int
+
+// Color primaries.
+const (
+	PrimariesUnspecified ColorPrimaries = iota
+	PrimariesBT709
+	PrimariesBT470M
+	PrimariesBT470BG
+	PrimariesSMPTE170M
+	PrimariesSMPTE240M
+	PrimariesFilm
+	PrimariesBT2020
+)
+
+var primariesCodes = [...]int32{
+	PrimariesUnspecified: 2,
+	PrimariesBT709:       1,
+	PrimariesBT470M:      4,
+	PrimariesBT470BG:     5,
+	PrimariesSMPTE170M:   6,
+	PrimariesSMPTE240M:   7,
+	PrimariesFilm:        8,
+	PrimariesBT2020:      9,
+}
+
+// ColorTransfer is the opto-electronic transfer characteristic, see H.264 Table E-4.
+type ColorTransfer int
+
+// Transfer characteristics.
+const (
+	TransferUnspecified ColorTransfer = iota
+	TransferBT709
+	TransferBT470M
+	TransferBT470BG
+	TransferSMPTE170M
+	TransferSMPTE240M
+	TransferLinear
+	// IEC 61966-2-1, sRGB.
+	TransferSRGB
+	// BT.2020 of 10-bit video.
+	TransferBT2020
+	// SMPTE ST 2084, the HDR10 perceptual quantizer.
+	TransferPQ
+)
+
+var transferCodes = [...]int32{
+	TransferUnspecified: 2,
+	TransferBT709:       1,
+	TransferBT470M:      4,
+	TransferBT470BG:     5,
+	TransferSMPTE170M:   6,
+	TransferSMPTE240M:   7,
+	TransferLinear:      8,
+	TransferSRGB:        13,
+	TransferBT2020:      14,
+	TransferPQ:          16,
+}
+
+// ColorMatrix is the matrix that derives YCbCr from RGB, see H.264 Table E-5.
+type ColorMatrix int
+
+// Matrices.
+const (
+	// BT.601 for YCbCr colorspaces, GBR for RGB colorspaces.
+	MatrixAuto ColorMatrix = iota
+	// Not signaled, images are converted with BT.601.
+	MatrixUnspecified
+	MatrixBT709
+	MatrixFCC
+	MatrixBT470BG
+	MatrixSMPTE170M
+	MatrixSMPTE240M
+	// BT.2020 non-constant luminance.
+	MatrixBT2020
+	// No matrix, the components are G, B, R.
+	MatrixGBR
+)
+
+var matrixCodes = [...]int32{
+	MatrixAuto:        6,
+	MatrixUnspecified: 2,
+	MatrixBT709:       1,
+	MatrixFCC:         4,
+	MatrixBT470BG:     5,
+	MatrixSMPTE170M:   6,
+	MatrixSMPTE240M:   7,
+	MatrixBT2020:      9,
+	MatrixGBR:         0,
+}
+
+// ColorRange is the range of the samples.
+type ColorRange int
+
+// Sample ranges.
+const (
+	// Samples use all the values, like Go YCbCr images.
+	RangeFull ColorRange = iota
+	// Luma is in [16, 235] and chroma in [16, 240] of 8 bits, the range of broadcast video.
+	RangeLimited
+)
+
+// ChromaLocation is the location of the 4:2:0 chroma samples relative to the luma samples.
+type ChromaLocation int
+
+// Chroma locations, see H.264 Figure E-1.
+const (
+	ChromaLeft ChromaLocation = iota
+	ChromaCenter
+	ChromaTopLeft
+	ChromaTop
+	ChromaBottomLeft
+	ChromaBottom
+)
+
+// validateColor checks the color description against the colorspace.
+func (o *Options) validateColor() error {
+	c := o.Color
+	if c.Primaries < 0 || int(c.Primaries) >= len(primariesCodes) {
+		return fmt.Errorf("%w: unknown color primaries %d", ErrInvalidOptions, c.Primaries)
+	}
+	if c.Transfer < 0 || int(c.Transfer) >= len(transferCodes) {
+		return fmt.Errorf("%w: unknown transfer characteristics %d", ErrInvalidOptions, c.Transfer)
+	}
+	if c.Matrix < 0 || int(c.Matrix) >= len(matrixCodes) {
+		return fmt.Errorf("%w: unknown color matrix %d", ErrInvalidOptions, c.Matrix)
+	}
+	if c.Range < RangeFull || c.Range > RangeLimited {
+		return fmt.Errorf("%w: unknown color range %d", ErrInvalidOptions, c.Range)
+	}
+	if c.ChromaLocation < ChromaLeft || c.ChromaLocation > ChromaBottom {
+		return fmt.Errorf("%w: unknown chroma location %d", ErrInvalidOptions, c.ChromaLocation)
+	}
+
+	// RGB pixels are encoded as they are
+	if o.Colorspace.rgb() {
+		if c.Matrix != MatrixAuto && c.Matrix != MatrixGBR {
+			return fmt.Errorf("%w: %v input is encoded as GBR, the matrix must be auto or GBR", ErrInvalidOptions, o.Colorspace)
+		}
+		if c.Range != RangeFull {
+			return fmt.Errorf("%w: %v input is encoded in the full range", ErrInvalidOptions, o.Colorspace)
+		}
+	} else if c.Matrix == MatrixGBR {
+		return fmt.Errorf("%w: %v input cannot be encoded as GBR", ErrInvalidOptions, o.Colorspace)
+	}
+
+	return nil
+}
+
+// applyColor sets the color description of the VUI.
+func applyColor(param *x264Param, opts *Options) {
+	c := opts.Color
+
+	param.Vui.IColorprim = primariesCodes[c.Primaries]
+	param.Vui.ITransfer = transferCodes[c.Transfer]
+	param.Vui.IColmatrix = matrixCodes[c.Matrix]
+	if opts.Colorspace.rgb() {
+		param.Vui.IColmatrix = matrixCodes[MatrixGBR]
+	}
+	param.Vui.BFullrange = 1
+	if c.Range == RangeLimited {
+		param.Vui.BFullrange = 0
+	}
+	param.Vui.IChromaLoc = int32(c.ChromaLocation)
+}
+
+// model returns the conversion of images into YCbCr frames of the matrix and range.
+func (c Color) model() imgcolor.Model {
+	var m color.Model
+	switch c.Matrix {
+	case MatrixBT709:
+		m = color.BT709
+	case MatrixFCC:
+		m = color.FCC
+	case MatrixSMPTE240M:
+		m = color.SMPTE240M
+	case MatrixBT2020:
+		m = color.BT2020
+	default:
+		if c.Range == RangeFull {
+			// the exact conversion of Go images
+			return imgcolor.YCbCrModel
+		}
+		m = color.BT601
+	}
+
+	m.Limited = c.Range == RangeLimited
+	return m
+}
+
+// AspectRatio is the ratio of a width to a height, e.g. 16:9.
+type AspectRatio struct {
+	Width, Height int
+}
+
+// Overscan is whether displays may crop the edges of the picture.
+type Overscan int
+
+// Overscan signaling.
+const (
+	// Not signaled.
+	OverscanUnspecified Overscan = iota
+	// The whole picture must be shown.
+	OverscanShow
+	// The picture is suitable for overscanned display, its edges may be cropped.
+	OverscanCrop
+)
+
+// sar returns the sample aspect ratio of the SPS reduced to the lowest terms, 0:0 if it is not set.
+// A display aspect ratio is turned into the sample aspect ratio of the displayed part of the frame.
+func (o *Options) sar() (w, h int64) {
+	switch {
+	case o.SampleAspectRatio != (AspectRatio{}):
+		w, h = int64(o.SampleAspectRatio.Width), int64(o.SampleAspectRatio.Height)
+	case o.DisplayAspectRatio != (AspectRatio{}):
+		size := o.display().Size()
+		w, h = int64(o.DisplayAspectRatio.Width)*int64(size.Y), int64(o.DisplayAspectRatio.Height)*int64(size.X)
+	default:
+		return 0, 0
+	}
+
+	d := gcd(w, h)
+	return w / d, h / d
+}
+
+// validateAspect checks the aspect ratio and overscan options.
+func (o *Options) validateAspect() error {
+	for _, r := range []AspectRatio{o.SampleAspectRatio, o.DisplayAspectRatio} {
+		if r != (AspectRatio{}) && (r.Width <= 0 || r.Height <= 0) {
+			return fmt.Errorf("%w: invalid aspect ratio %d:%d", ErrInvalidOptions, r.Width, r.Height)
+		}
+	}
+	if o.SampleAspectRatio != (AspectRatio{}) && o.DisplayAspectRatio != (AspectRatio{}) {
+		return fmt.Errorf("%w: sample and display aspect ratios are set together", ErrInvalidOptions)
+	}
+	// the SPS has 16 bits for each term
+	if w, h := o.sar(); w > math.MaxUint16 || h > math.MaxUint16 {
+		return fmt.Errorf("%w: sample aspect ratio %d:%d is too big", ErrInvalidOptions, w, h)
+	}
+
+	if o.Overscan < OverscanUnspecified || o.Overscan > OverscanCrop {
+		return fmt.Errorf("%w: unknown overscan %d", ErrInvalidOptions, o.Overscan)
+	}
+
+	return nil
+}
+
+// applyAspect sets the sample aspect ratio and overscan of the VUI.
+func applyAspect(param *x264Param, opts *Options) {
+	if w, h := opts.sar(); w > 0 {
+		param.Vui.ISarWidth = int32(w)
+		param.Vui.ISarHeight = int32(h)
+	}
+	param.Vui.IOverscan = int32(opts.Overscan)
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
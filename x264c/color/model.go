@@ -0,0 +1,51 @@
+package color
+
+import (
+	"image/color"
+	"math"
+)
+
+// Model converts colors to Y'CbCr with the luma coefficients Kr and Kb of a matrix,
+// in the full range like color.YCbCrModel or in the limited video range.
+// color.YCbCr colors are JFIF like everywhere in Go, they are converted unless the model is the JFIF one.
+type Model struct {
+	Kr, Kb float64
+	// Y' in [16, 235] and Cb, Cr in [16, 240] instead of [0, 255].
+	Limited bool
+}
+
+// Luma coefficients of the common matrices.
+var (
+	BT601     = Model{Kr: 0.299, Kb: 0.114}
+	BT709     = Model{Kr: 0.2126, Kb: 0.0722}
+	BT2020    = Model{Kr: 0.2627, Kb: 0.0593}
+	SMPTE240M = Model{Kr: 0.212, Kb: 0.087}
+	FCC       = Model{Kr: 0.30, Kb: 0.11}
+)
+
+// jfif is the conversion of color.YCbCrModel.
+var jfif = Model{Kr: 0.299, Kb: 0.114}
+
+// Convert converts c to color.YCbCr.
+func (m Model) Convert(c color.Color) color.Color {
+	if ycc, ok := c.(color.YCbCr); ok && m == jfif {
+		return ycc
+	}
+
+	// premultiplied like color.YCbCrModel, alpha is ignored
+	r, g, b, _ := c.RGBA()
+	rf, gf, bf := float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff
+
+	y := m.Kr*rf + (1-m.Kr-m.Kb)*gf + m.Kb*bf
+	cb := (bf - y) / (2 * (1 - m.Kb))
+	cr := (rf - y) / (2 * (1 - m.Kr))
+
+	if m.Limited {
+		return color.YCbCr{Y: clamp8(16 + 219*y), Cb: clamp8(128 + 224*cb), Cr: clamp8(128 + 224*cr)}
+	}
+	return color.YCbCr{Y: clamp8(255 * y), Cb: clamp8(128 + 255*cb), Cr: clamp8(128 + 255*cr)}
+}
+
+func clamp8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
@@ -0,0 +1,71 @@
+package color
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestModel(t *testing.T) {
+	// the full range BT.601 model is the JFIF conversion of Go within rounding
+	for _, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {12, 200, 99, 255}, {255, 255, 255, 255}} {
+		got := BT601.Convert(c).(color.YCbCr)
+		want := color.YCbCrModel.Convert(c).(color.YCbCr)
+		if diff(got.Y, want.Y) > 1 || diff(got.Cb, want.Cb) > 1 || diff(got.Cr, want.Cr) > 1 {
+			t.Errorf("%v: got %v, want %v", c, got, want)
+		}
+	}
+
+	limited := BT709
+	limited.Limited = true
+	tests := []struct {
+		c    color.Color
+		want color.YCbCr
+	}{
+		{color.Black, color.YCbCr{Y: 16, Cb: 128, Cr: 128}},
+		{color.White, color.YCbCr{Y: 235, Cb: 128, Cr: 128}},
+		{color.RGBA{255, 0, 0, 255}, color.YCbCr{Y: 63, Cb: 102, Cr: 240}},
+		{color.RGBA{0, 0, 255, 255}, color.YCbCr{Y: 32, Cb: 240, Cr: 118}},
+		// Y'CbCr colors are JFIF
+		{color.YCbCr{Y: 255, Cb: 128, Cr: 128}, color.YCbCr{Y: 235, Cb: 128, Cr: 128}},
+		{color.YCbCr{Y: 0, Cb: 128, Cr: 128}, color.YCbCr{Y: 16, Cb: 128, Cr: 128}},
+	}
+	for _, test := range tests {
+		if got := limited.Convert(test.c); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.c, got, test.want)
+		}
+	}
+
+	// JFIF colors are kept by the JFIF model
+	if c := (color.YCbCr{Y: 1, Cb: 2, Cr: 3}); BT601.Convert(c) != c {
+		t.Errorf("got %v, want %v", BT601.Convert(c), c)
+	}
+
+	// images convert the colors that are set with their model
+	p := NewYCbCr(image.Rect(0, 0, 16, 16))
+	p.Model = limited
+	p.Set(0, 0, color.Black)
+	if got := p.YCbCrAt(0, 0); got.Y != 16 {
+		t.Errorf("got %v, want limited black", got)
+	}
+
+	// and Go images of another model
+	src := image.NewYCbCr(p.Rect, image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = 255
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 128, 128
+	}
+	p.ToYCbCr(src)
+	if got := p.YCbCrAt(5, 5); got.Y != 235 {
+		t.Errorf("got %v, want limited white", got)
+	}
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
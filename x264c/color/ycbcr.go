@@ -12,11 +12,21 @@ import (
 // YCbCr is an in-memory image of Y'CbCr colors.
 type YCbCr struct {
 	*image.YCbCr
+	// Conversion of the colors that are set, nil means color.YCbCrModel.
+	Model color.Model
 }
 
 // NewYCbCr returns a new YCbCr image with the given bounds and subsample ratio.
 func NewYCbCr(r image.Rectangle) *YCbCr {
-	return &YCbCr{image.NewYCbCr(r, image.YCbCrSubsampleRatio420)}
+	return &YCbCr{YCbCr: image.NewYCbCr(r, image.YCbCrSubsampleRatio420)}
+}
+
+// ColorModel returns the conversion of the colors that are set.
+func (p *YCbCr) ColorModel() color.Model {
+	if p.Model != nil {
+		return p.Model
+	}
+	return color.YCbCrModel
 }
 
 // Set sets pixel color.
@@ -37,10 +47,47 @@ func (p *YCbCr) setYCbCr(x, y int, c color.YCbCr) {
 	p.Cr[ci] = c.Cr
 }
 
-// ToYCbCr converts image.Image to YCbCr.
+// ToYCbCr converts image.Image to YCbCr, images of another model are converted pixel by pixel.
 func (p *YCbCr) ToYCbCr(src image.Image) {
+	// Go YCbCr images are JFIF
+	var m color.Model = color.YCbCrModel
+	if s, ok := src.(*YCbCr); ok {
+		src, m = s.YCbCr, s.ColorModel()
+	}
+
+	// same layout and model, planes are copied row by row
+	s, ok := src.(*image.YCbCr)
+	if ok && m == p.ColorModel() && s.SubsampleRatio == p.SubsampleRatio && s.Rect.Eq(p.Rect) {
+		p.copyPlanes(s)
+		return
+	}
+
+	// the image is drawn at the origin of p whatever its own origin
 	bounds := src.Bounds()
-	draw.Draw(p, bounds, src, bounds.Min, draw.Src)
+	draw.Draw(p, bounds.Sub(bounds.Min).Add(p.Rect.Min), src, bounds.Min, draw.Src)
+}
+
+// copyPlanes copies planes of the image with the same bounds and subsample ratio.
+func (p *YCbCr) copyPlanes(src *image.YCbCr) {
+	r := p.Rect
+	if r.Empty() {
+		return
+	}
+
+	ci := -1
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		yi := p.YOffset(r.Min.X, y)
+		copy(p.Y[yi:yi+r.Dx()], src.Y[src.YOffset(r.Min.X, y):])
+
+		// chroma rows are shared by several luma rows when subsampled vertically
+		if next := p.COffset(r.Min.X, y); next != ci {
+			ci = next
+			cw := p.COffset(r.Max.X-1, y) - ci + 1
+			sci := src.COffset(r.Min.X, y)
+			copy(p.Cb[ci:ci+cw], src.Cb[sci:sci+cw])
+			copy(p.Cr[ci:ci+cw], src.Cr[sci:sci+cw])
+		}
+	}
 }
 
 // Copy arbitrary YCbCr to buffer that allocated by x264_picture_alloc()
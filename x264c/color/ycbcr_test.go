@@ -2,6 +2,7 @@ package color
 
 import (
 	"image"
+	"image/color"
 	"testing"
 )
 
@@ -20,3 +21,39 @@ func TestYCbCr(t *testing.T) {
 		t.Error("ToYCbCr failed")
 	}
 }
+
+func TestYCbCrCopy(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 33, 17), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = byte(i)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = byte(i), byte(255-i)
+	}
+
+	dst := NewYCbCr(src.Rect)
+	dst.ToYCbCr(src)
+
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 33; x++ {
+			if got, want := dst.YCbCrAt(x, y), src.YCbCrAt(x, y); got != want {
+				t.Fatalf("pixel %d,%d: got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// different bounds go through draw
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(1, 1, color.White)
+	small := NewYCbCr(image.Rect(0, 0, 16, 16))
+	small.ToYCbCr(img)
+	if got := small.YCbCrAt(1, 1); got.Y != 255 {
+		t.Errorf("got %v, want white", got)
+	}
+
+	// images are drawn at the origin whatever their own origin
+	small.ToYCbCr(img.SubImage(image.Rect(1, 1, 8, 8)))
+	if got := small.YCbCrAt(0, 0); got.Y != 255 {
+		t.Errorf("sub-image: got %v, want white", got)
+	}
+}
@@ -0,0 +1,67 @@
+package external
+
+/*
+#include <stdarg.h>
+#include <stdint.h>
+#include <stdio.h>
+
+extern void x264goLogExternal(void *priv, int level, char *msg);
+
+void x264go_log_external(void *priv, int level, const char *fmt, va_list args) {
+	char msg[1024];
+	vsnprintf(msg, sizeof(msg), fmt, args);
+	x264goLogExternal(priv, level, msg);
+}
+
+static void *x264go_log_id(uintptr_t id) {
+	return (void *)id;
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// LogFunc receives a formatted log message of the level.
+type LogFunc func(level int32, msg string)
+
+// log callbacks by the id passed to x264 as the log private pointer
+var logs = struct {
+	sync.Mutex
+	fns  map[uintptr]LogFunc
+	last uintptr
+}{fns: make(map[uintptr]LogFunc)}
+
+// LogSet - route log messages of the encoder opened with param to fn instead of stderr.
+// Returns the id of the callback, it must be released with LogFree after the encoder is closed.
+func LogSet(param *Param, fn LogFunc) uintptr {
+	logs.Lock()
+	logs.last++
+	id := logs.last
+	logs.fns[id] = fn
+	logs.Unlock()
+
+	param.PfLog = (*[0]byte)(unsafe.Pointer(C.x264go_log_external))
+	param.PLogPrivate = C.x264go_log_id(C.uintptr_t(id))
+
+	return id
+}
+
+// LogFree - release the callback set with LogSet.
+func LogFree(id uintptr) {
+	logs.Lock()
+	delete(logs.fns, id)
+	logs.Unlock()
+}
+
+func logCallback(priv unsafe.Pointer, level int32, msg string) {
+	logs.Lock()
+	fn := logs.fns[uintptr(priv)]
+	logs.Unlock()
+
+	if fn != nil {
+		fn(level, msg)
+	}
+}
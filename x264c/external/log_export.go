@@ -0,0 +1,12 @@
+package external
+
+// #include <stdlib.h>
+import "C"
+
+import "unsafe"
+
+// x264goLogExternal is called by x264 through the log callback set with LogSet.
+//export x264goLogExternal
+func x264goLogExternal(priv unsafe.Pointer, level C.int, msg *C.char) {
+	logCallback(priv, int32(level), C.GoString(msg))
+}
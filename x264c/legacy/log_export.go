@@ -0,0 +1,12 @@
+package legacy
+
+// #include <stdlib.h>
+import "C"
+
+import "unsafe"
+
+// x264goLogLegacy is called by x264 through the log callback set with LogSet.
+//export x264goLogLegacy
+func x264goLogLegacy(priv unsafe.Pointer, level C.int, msg *C.char) {
+	logCallback(priv, int32(level), C.GoString(msg))
+}
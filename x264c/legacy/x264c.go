@@ -5,6 +5,13 @@ package legacy
 #include "stdint.h"
 #include "x264.h"
 #include <stdlib.h>
+
+// x264go_sei_set references n payloads from the SEI of a picture, x264 frees them and the array with free.
+static void x264go_sei_set(x264_sei_t *sei, int n, x264_sei_payload_t *payloads) {
+	sei->num_payloads = n;
+	sei->payloads = payloads;
+	sei->sei_free = free;
+}
 */
 import "C"
 
@@ -218,16 +225,16 @@ const (
 
 // PicStruct enumeration.
 const (
-	PicStructAuto        = int32(iota) // automatically decide (default)
-	PicStructProgressive               // progressive frame
+	PicStructAuto        = int32(0) // automatically decide (default)
+	PicStructProgressive = int32(1) // progressive frame
 
 	// "TOP" and "BOTTOM" are not supported in x264 (PAFF only)
-	PicStructTopBottom       // top field followed by bottom
-	PicStructBottomTop       // bottom field followed by top
-	PicStructTopBottomTop    // top field, bottom field, top field repeated
-	PicStructBottomTopBottom // bottom field, top field, bottom field repeated
-	PicStructDouble          // double frame
-	PicStructTriple          // triple frame
+	PicStructTopBottom       = int32(4) // top field followed by bottom
+	PicStructBottomTop       = int32(5) // bottom field followed by top
+	PicStructTopBottomTop    = int32(6) // top field, bottom field, top field repeated
+	PicStructBottomTopBottom = int32(7) // bottom field, top field, bottom field repeated
+	PicStructDouble          = int32(8) // double frame
+	PicStructTriple          = int32(9) // triple frame
 )
 
 // T opaque handler for encoder.
@@ -957,3 +964,68 @@ func EncoderInvalidateReference(enc *T, pts int) int32 {
 	v := (int32)(ret)
 	return v
 }
+
+// BitDepth - return the bit depth the library encodes in, the value of x264_bit_depth.
+// Samples of input with a depth above 8 take 16 bits, with the CspHighDepth flag set in the colorspace.
+func BitDepth() int32 {
+	return int32(C.x264_bit_depth)
+}
+
+// ZonesAlloc - allocate n zeroed zones in C memory, so they can be referenced from Param.Rc.Zones.
+// The zones must be released with ZonesFree, returns nil if n is not positive or on failure.
+func ZonesAlloc(n int) []Zone {
+	if n <= 0 {
+		return nil
+	}
+
+	p := C.calloc(C.size_t(n), C.sizeof_x264_zone_t)
+	if p == nil {
+		return nil
+	}
+
+	return (*[1 << 20]Zone)(p)[:n:n]
+}
+
+// ZonesFree - free zones allocated with ZonesAlloc.
+func ZonesFree(zones []Zone) {
+	if len(zones) == 0 {
+		return
+	}
+	czones := zones[0].cptr()
+	C.free(unsafe.Pointer(czones))
+}
+
+// cptr return C pointer.
+func (z *Zone) cptr() *C.x264_zone_t {
+	return (*C.x264_zone_t)(unsafe.Pointer(z))
+}
+
+// SeiSet - copy the SEI payloads of the types into C memory and reference them from sei.
+// x264 releases them with sei_free once they are written or the frame is dropped.
+// Returns false on failure.
+func SeiSet(sei *Sei, types []int32, payloads [][]byte) bool {
+	n := len(payloads)
+	if n == 0 {
+		return true
+	}
+
+	p := C.calloc(C.size_t(n), C.sizeof_x264_sei_payload_t)
+	if p == nil {
+		return false
+	}
+
+	cpayloads := (*[1 << 16]C.x264_sei_payload_t)(p)[:n:n]
+	for i, b := range payloads {
+		cpayloads[i].payload_type = C.int(types[i])
+		cpayloads[i].payload_size = C.int(len(b))
+		cpayloads[i].payload = (*C.uint8_t)(C.CBytes(b))
+	}
+
+	C.x264go_sei_set(sei.cptr(), C.int(n), (*C.x264_sei_payload_t)(p))
+	return true
+}
+
+// cptr return C pointer.
+func (s *Sei) cptr() *C.x264_sei_t {
+	return (*C.x264_sei_t)(unsafe.Pointer(s))
+}